@@ -0,0 +1,49 @@
+package main
+
+import "agentX/rpc"
+
+//Admin is the rpc service to manage agentX itself
+type Admin struct{}
+
+//Reload reloads the config file,out is the list of changed keys
+func (a *Admin) Reload(out *[]string) (err error) {
+	changes, err := reloadConfig()
+	if err != nil {
+		return
+	}
+	*out = append([]string{}, changes...)
+	return
+}
+
+//Status is the agent-wide health rolled up from the services
+type Status struct {
+	//Status is "ok" or "degraded" if any service is unhealthy
+	Status   string            `json:"status"`
+	Services map[string]string `json:"services"`
+}
+
+//Status reports the health of each service
+func (a *Admin) Status(out *Status) (err error) {
+	healthy, services := srv.Health()
+	out.Status = "ok"
+	if !healthy {
+		out.Status = "degraded"
+	}
+	out.Services = services
+	return
+}
+
+//Registration reports the state of the registration client
+func (a *Admin) Registration(out *RegistrationState) (err error) {
+	if register != nil {
+		*out = register.State()
+	}
+	return
+}
+
+//Compression reports the bytes of the compressed responses and messages
+//before and after compression by encoding
+func (a *Admin) Compression(out *map[string]rpc.CompressionStat) (err error) {
+	*out = srv.CompressionStats()
+	return
+}
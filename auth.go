@@ -0,0 +1,125 @@
+package main
+
+import (
+	"agentX/rpc"
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+//authorize is the rpc.AuthFunc of the server,callers on the unix socket
+//running as a trusted user need no token,others need one of rpc.tokens
+//if any is configured.
+func authorize(r *http.Request, token string) error {
+	c := conf()
+	if peer := rpc.PeerOf(r); peer != nil {
+		if c.RPC.Unix.trusted(peer.UID) {
+			return nil
+		}
+	}
+	if len(c.RPC.Tokens) == 0 {
+		return nil
+	}
+	for _, t := range c.RPC.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return nil
+		}
+	}
+	return fmt.Errorf("bad token")
+}
+
+//trusted returns true if uid is one of the trusted users
+func (u UnixConfig) trusted(uid uint32) bool {
+	for _, name := range u.TrustedUsers {
+		if id, err := lookupUID(name); err == nil && id == uid {
+			return true
+		}
+	}
+	return false
+}
+
+//lookupUID returns the uid of a user name or numeric uid
+func lookupUID(name string) (uint32, error) {
+	if id, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(id), nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseUint(u.Uid, 10, 32)
+	return uint32(id), err
+}
+
+//fileMode returns the socket file mode
+func (u UnixConfig) fileMode() (os.FileMode, error) {
+	mode, err := strconv.ParseUint(u.Mode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("expected octal mode like \"0660\",got %q", u.Mode)
+	}
+	return os.FileMode(mode), nil
+}
+
+//gid returns the gid of the socket group,-1 if not set
+func (u UnixConfig) gid() (int, error) {
+	if u.Group == "" {
+		return -1, nil
+	}
+	if id, err := strconv.Atoi(u.Group); err == nil {
+		return id, nil
+	}
+	g, err := user.LookupGroup(u.Group)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+//validate checks [rpc.unix]
+func (u UnixConfig) validate() (errs []string) {
+	if _, err := u.fileMode(); err != nil {
+		errs = append(errs, fmt.Sprintf("rpc.unix.mode : %s", err))
+	}
+	if _, err := u.gid(); err != nil {
+		errs = append(errs, fmt.Sprintf("rpc.unix.group : %s", err))
+	}
+	for _, name := range u.TrustedUsers {
+		if _, err := lookupUID(name); err != nil {
+			errs = append(errs, fmt.Sprintf("rpc.unix.trustedUsers : %s", err))
+		}
+	}
+	return
+}
+
+//tlsConfig returns the tls config of the raw tcp listener,nil without tls
+func (t TCPConfig) tlsConfig() (*tls.Config, error) {
+	if t.TLSCert == "" && t.TLSKey == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(t.TLSCert, t.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+//validate checks [rpc.tcp]
+func (t TCPConfig) validate() (errs []string) {
+	if t.Listen == "" {
+		return
+	}
+	if _, _, err := net.SplitHostPort(t.Listen); err != nil {
+		errs = append(errs, fmt.Sprintf("rpc.tcp.listen : %s", err))
+	}
+	if (t.TLSCert == "") != (t.TLSKey == "") {
+		errs = append(errs, "rpc.tcp : tlsCert and tlsKey should be set together")
+	} else if _, err := t.tlsConfig(); err != nil {
+		errs = append(errs, fmt.Sprintf("rpc.tcp : %s", err))
+	}
+	return
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"agentX/client"
+	"agentX/rpc"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+//exit codes of `agentx call`
+const (
+	exitOK          = 0
+	exitCallError   = 1
+	exitUsage       = 2
+	exitUnreachable = 3
+)
+
+//call and shell flags
+var (
+	cliToken   *string
+	cliTimeout *int
+)
+
+func cliFlags(fs *pflag.FlagSet) {
+	cliToken = fs.String("token", os.Getenv("AGENTX_TOKEN"), "token of the agent,$AGENTX_TOKEN by default")
+	cliTimeout = fs.Int("timeout", 30, "seconds to wait for a call")
+}
+
+//agentURL returns the json-rpc url of the agent at addr,addr is host:port
+//or a url,the token is added as path if the url has none.
+func agentURL(addr, scheme string) string {
+	token := *cliToken
+	if token == "" {
+		token = "none"
+	}
+	if !strings.Contains(addr, "://") {
+		return scheme + "://" + addr + "/" + token
+	}
+	if i := strings.Index(addr[strings.Index(addr, "://")+3:], "/"); i < 0 {
+		return addr + "/" + token
+	}
+	return addr
+}
+
+//prettyJSON indents raw json
+func prettyJSON(raw []byte) string {
+	var b bytes.Buffer
+	if err := json.Indent(&b, raw, "", "  "); err != nil {
+		return string(raw)
+	}
+	return b.String()
+}
+
+//callCommand is `agentx call <addr> <method> [params]`,it prints the result
+//as pretty json,params "-" is read from stdin. Exit codes are exitOK,
+//exitCallError when the method fails,exitUsage and exitUnreachable.
+func callCommand(args []string) int {
+	if len(args) < 2 || len(args) > 3 {
+		fmt.Fprintln(os.Stderr, "usage: agentx call <host:port|url> <method> [params json|-] [--token token] [--timeout seconds]")
+		return exitUsage
+	}
+	var params interface{}
+	if len(args) == 3 {
+		raw := []byte(args[2])
+		if args[2] == "-" {
+			var err error
+			if raw, err = ioutil.ReadAll(os.Stdin); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return exitUsage
+			}
+		}
+		if !json.Valid(raw) {
+			fmt.Fprintln(os.Stderr, "params should be json")
+			return exitUsage
+		}
+		params = json.RawMessage(raw)
+	}
+	c, err := client.Dial(agentURL(args[0], "http"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUnreachable
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(*cliTimeout)*time.Second)
+	defer cancel()
+	var result json.RawMessage
+	err = c.CallWithProgress(ctx, args[1], params, &result, func(progress json.RawMessage) {
+		fmt.Fprintf(os.Stderr, "progress %s\n", progress)
+	})
+	if err != nil {
+		if e, ok := err.(*client.Error); ok {
+			b, _ := json.Marshal(e)
+			fmt.Fprintln(os.Stderr, prettyJSON(b))
+			return exitCallError
+		}
+		fmt.Fprintln(os.Stderr, err)
+		return exitUnreachable
+	}
+	fmt.Println(prettyJSON(result))
+	return exitOK
+}
+
+//shell is the state of `agentx shell`
+type shell struct {
+	c        *client.Client
+	services []rpc.ServiceInfo
+	//methods are the call names of the methods with their params schema
+	methods map[string]*rpc.Schema
+	history []string
+	//notifications are printed while watching
+	notifications chan string
+}
+
+//shellCommands are the built-in commands of the shell
+var shellCommands = []string{"help", "methods", "watch", "exit"}
+
+//shellCommand is `agentx shell <addr>`,a REPL calling the agent over
+//websocket with history,completion of method names and params from
+//rpc.discover and a watch mode printing the notifications of the agent.
+func shellCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: agentx shell <host:port|url> [--token token] [--timeout seconds]")
+		return exitUsage
+	}
+	sh := &shell{notifications: make(chan string, 100), methods: map[string]*rpc.Schema{}}
+	c, err := client.Dial(agentURL(args[0], "ws"), client.WithNotificationHandler(func(method string, params json.RawMessage) {
+		select {
+		case sh.notifications <- fmt.Sprintf("%s %s", method, params):
+		default:
+		}
+	}))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUnreachable
+	}
+	defer c.Close()
+	sh.c = c
+	if err = sh.discover(); err != nil {
+		fmt.Fprintf(os.Stderr, "discover fail,no completion : %s\n", err)
+	}
+	sh.loadHistory()
+	defer sh.saveHistory()
+	fmt.Printf("connected to %s,type help for help\n", args[0])
+	reader := newLineReader(os.Stdin, os.Stdout, sh.complete)
+	reader.history = sh.history
+	for {
+		line, err := reader.ReadLine("agentx> ")
+		if err != nil {
+			if err != io.EOF {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			sh.history = reader.history
+			return 0
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if line == "exit" || line == "quit" {
+			sh.history = reader.history
+			return 0
+		}
+		sh.run(line, reader)
+		sh.history = reader.history
+	}
+}
+
+//discover loads the methods from rpc.discover
+func (sh *shell) discover() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(*cliTimeout)*time.Second)
+	defer cancel()
+	if err := sh.c.Call(ctx, rpc.DiscoverMethod, nil, &sh.services); err != nil {
+		return err
+	}
+	for _, s := range sh.services {
+		for _, m := range s.Methods {
+			for _, name := range append([]string{m.Name}, m.Names...) {
+				sh.methods[s.Name+"."+name] = m.Params
+			}
+		}
+	}
+	return nil
+}
+
+//run runs a line of the shell
+func (sh *shell) run(line string, reader *lineReader) {
+	word, rest := line, ""
+	if i := strings.IndexAny(line, " \t"); i > 0 {
+		word, rest = line[:i], strings.TrimSpace(line[i+1:])
+	}
+	switch word {
+	case "help":
+		fmt.Println("<method> [params json]  call a method,tab completes methods and params")
+		fmt.Println("methods                 list the methods of the agent")
+		fmt.Println("watch [method [params]] call method if given and print notifications until enter")
+		fmt.Println("exit                    leave the shell")
+		return
+	case "methods":
+		for _, s := range sh.services {
+			for _, m := range s.Methods {
+				names := ""
+				if len(m.Names) > 0 {
+					names = " (" + strings.Join(m.Names, ",") + ")"
+				}
+				fmt.Printf("%s.%s%s\n", s.Name, m.Name, names)
+			}
+		}
+		return
+	case "watch":
+		sh.watch(rest, reader)
+		return
+	}
+	sh.call(word, rest)
+}
+
+//call calls method and prints the result and progress
+func (sh *shell) call(method, params string) bool {
+	var p interface{}
+	if params != "" {
+		if !json.Valid([]byte(params)) {
+			fmt.Println("params should be json")
+			return false
+		}
+		p = json.RawMessage(params)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(*cliTimeout)*time.Second)
+	defer cancel()
+	var result json.RawMessage
+	err := sh.c.CallWithProgress(ctx, method, p, &result, func(progress json.RawMessage) {
+		fmt.Printf("progress %s\n", progress)
+	})
+	if err != nil {
+		fmt.Printf("error : %s\n", err)
+		return false
+	}
+	fmt.Println(prettyJSON(result))
+	return true
+}
+
+//watch calls the subscribe method in line if any,then prints the
+//notifications until enter is pressed
+func (sh *shell) watch(line string, reader *lineReader) {
+	if line != "" {
+		method, params := line, ""
+		if i := strings.IndexAny(line, " \t"); i > 0 {
+			method, params = line[:i], strings.TrimSpace(line[i+1:])
+		}
+		if !sh.call(method, params) {
+			return
+		}
+	}
+	fmt.Println("watching notifications,press enter to stop")
+	stop := make(chan bool)
+	go func() {
+		reader.WaitKey()
+		close(stop)
+	}()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	for {
+		select {
+		case n := <-sh.notifications:
+			fmt.Printf("%s %s\n", time.Now().Format("15:04:05.000"), n)
+		case <-stop:
+			return
+		case <-interrupt:
+			return
+		}
+	}
+}
+
+//complete returns the completions of line,method names for the first word
+//and a params skeleton from the schema after a method.
+func (sh *shell) complete(line string) (completions []string) {
+	if i := strings.IndexAny(line, " \t"); i > 0 {
+		word, rest := line[:i], strings.TrimSpace(line[i+1:])
+		if word == "watch" {
+			for _, c := range sh.complete(rest) {
+				completions = append(completions, "watch "+c)
+			}
+			return
+		}
+		schema, ok := sh.methods[word]
+		if !ok || rest != "" || schema == nil {
+			return
+		}
+		return []string{word + " " + skeleton(schema)}
+	}
+	candidates := append([]string{}, shellCommands...)
+	for name := range sh.methods {
+		candidates = append(candidates, name)
+	}
+	sort.Strings(candidates)
+	for _, c := range candidates {
+		if strings.HasPrefix(c, line) {
+			completions = append(completions, c)
+		}
+	}
+	return
+}
+
+//skeleton returns a json value of schema with empty values
+func skeleton(schema *rpc.Schema) string {
+	switch schema.Type {
+	case "object":
+		if len(schema.Properties) == 0 {
+			return "{}"
+		}
+		keys := []string{}
+		for k := range schema.Properties {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		parts := []string{}
+		for _, k := range keys {
+			parts = append(parts, fmt.Sprintf("%q:%s", k, skeleton(schema.Properties[k])))
+		}
+		return "{" + strings.Join(parts, ",") + "}"
+	case "array":
+		return "[]"
+	case "string":
+		return `""`
+	case "integer", "number":
+		return "0"
+	case "boolean":
+		return "false"
+	}
+	return "null"
+}
+
+const maxHistory = 500
+
+func historyFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".agentx_history")
+}
+
+func (sh *shell) loadHistory() {
+	file, err := os.Open(historyFile())
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			sh.history = append(sh.history, line)
+		}
+	}
+}
+
+func (sh *shell) saveHistory() {
+	if historyFile() == "" {
+		return
+	}
+	if len(sh.history) > maxHistory {
+		sh.history = sh.history[len(sh.history)-maxHistory:]
+	}
+	ioutil.WriteFile(historyFile(), []byte(strings.Join(sh.history, "\n")+"\n"), 0600)
+}
@@ -0,0 +1,465 @@
+// Package client is the go client of agentX.
+//
+// a Client calls an agent over http or websocket,the url is the one the
+// agent serves json-rpc on,with the token as path:
+//   c, err := client.Dial("ws://10.0.0.1:9091/token")
+//   defer c.Close()
+//   var now string
+//   err = c.Call(ctx, "system.Time", nil, &now)
+// websocket clients receive notifications and progress of calls,answer
+// the requests of the agent,see WithRequestHandler,and reconnect with
+// backoff when the connection is lost.
+package client
+
+import (
+	"agentX/rpc"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrClosed is returned by calls of a closed Client.
+var ErrClosed = errors.New("client: closed")
+
+// ErrDisconnected fails the calls in flight when the websocket is lost.
+var ErrDisconnected = errors.New("client: disconnected")
+
+// Error is a json-rpc error returned by the agent.
+type Error struct {
+	Code    int             `json:"code"`
+	Message interface{}     `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v (code %d)", e.Message, e.Code)
+}
+
+// NotificationHandler receives the notifications pushed by the agent.
+type NotificationHandler func(method string, params json.RawMessage)
+
+// RequestHandler answers the requests the agent sends over websocket,like
+// rpc.ConfirmMethod,err should be an *Error to set the error code.
+type RequestHandler func(method string, params json.RawMessage) (result interface{}, err error)
+
+// ProgressFunc receives the progress data of a call.
+type ProgressFunc func(progress json.RawMessage)
+
+// Option configures a Client.
+type Option func(c *Client)
+
+// WithHTTPClient sends http calls with hc,http.DefaultClient by default.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithHeader adds header to http requests and the websocket handshake.
+func WithHeader(header http.Header) Option {
+	return func(c *Client) {
+		c.header = header
+	}
+}
+
+// WithNotificationHandler passes the notifications of the agent to h.
+func WithNotificationHandler(h NotificationHandler) Option {
+	return func(c *Client) {
+		c.onNotify = h
+	}
+}
+
+// WithRequestHandler answers the requests of the agent with h,they are
+// answered with a method not found error otherwise.
+func WithRequestHandler(h RequestHandler) Option {
+	return func(c *Client) {
+		c.onRequest = h
+	}
+}
+
+// WithResume asks the agent for a session and resumes it when the websocket
+// reconnects,the calls in flight get their responses after the reconnect
+// instead of failing with ErrDisconnected,unless the session expired.
+func WithResume() Option {
+	return func(c *Client) {
+		c.resume = true
+	}
+}
+
+// WithBackoff sets the interval between websocket reconnects,it starts
+// at min and doubles up to max,1s and 30s by default.
+func WithBackoff(min, max time.Duration) Option {
+	return func(c *Client) {
+		c.backoffMin, c.backoffMax = min, max
+	}
+}
+
+// transport sends request bodies to the agent,responses are passed to
+// deliver,synchronously for http and by the read loop for websocket.
+type transport interface {
+	send(ctx context.Context, body []byte, deliver func([]byte)) error
+	close() error
+}
+
+// Client is a json-rpc client of an agent,it is safe for concurrent use.
+type Client struct {
+	url        string
+	httpClient *http.Client
+	header     http.Header
+	onNotify   NotificationHandler
+	onRequest  RequestHandler
+	backoffMin time.Duration
+	backoffMax time.Duration
+	resume     bool
+	transport  transport
+
+	nextID  uint64
+	mutex   sync.Mutex
+	pending map[string]*pendingCall
+	closed  bool
+	session string // id of the session on the agent,see WithResume
+	lost    uint64 // the calls up to this id wait for the session to resume
+}
+
+type pendingCall struct {
+	done     chan *response
+	progress ProgressFunc
+}
+
+type request struct {
+	Version string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+	Id      *uint64     `json:"id,omitempty"`
+}
+
+type response struct {
+	Method string           `json:"method"`
+	Params json.RawMessage  `json:"params"`
+	Result *json.RawMessage `json:"result"`
+	Error  *Error           `json:"error"`
+	Id     *json.RawMessage `json:"id"`
+	err    error
+}
+
+// Dial returns a client of the agent at rawurl,http:// and https:// urls
+// use the http transport,ws:// and wss:// urls a websocket which is
+// connected before Dial returns.
+func Dial(rawurl string, opts ...Option) (c *Client, err error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return
+	}
+	c = &Client{
+		url:        rawurl,
+		httpClient: http.DefaultClient,
+		header:     http.Header{},
+		backoffMin: time.Second,
+		backoffMax: time.Second * 30,
+		pending:    map[string]*pendingCall{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		c.transport = &httpTransport{c: c}
+	case "ws", "wss":
+		var t *wsTransport
+		if t, err = dialWS(c); err != nil {
+			return nil, err
+		}
+		c.transport = t
+	default:
+		return nil, fmt.Errorf("client: unsupported url scheme %q", u.Scheme)
+	}
+	return
+}
+
+// Call calls method with params and decodes the result into result,
+// which may be nil to discard it.
+func (c *Client) Call(ctx context.Context, method string, params, result interface{}) error {
+	return c.CallWithProgress(ctx, method, params, result, nil)
+}
+
+// CallWithProgress is like Call and passes the progress the method reports
+// to progress,progress is only sent over websocket.
+func (c *Client) CallWithProgress(ctx context.Context, method string, params, result interface{}, progress ProgressFunc) error {
+	id := atomic.AddUint64(&c.nextID, 1)
+	p, err := c.register(id, progress)
+	if err != nil {
+		return err
+	}
+	defer c.unregister(id)
+	body, err := json.Marshal(request{Version: "2.0", Method: method, Params: params, Id: &id})
+	if err != nil {
+		return err
+	}
+	if err = c.transport.send(ctx, body, c.deliver); err != nil {
+		return err
+	}
+	return c.wait(ctx, p, result)
+}
+
+// Notify sends a call of method which is not answered.
+func (c *Client) Notify(ctx context.Context, method string, params interface{}) error {
+	body, err := json.Marshal(request{Version: "2.0", Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	return c.transport.send(ctx, body, c.deliver)
+}
+
+// BatchCall is a call of a batch,Error is set after Batch returns.
+type BatchCall struct {
+	Method string
+	Params interface{}
+	Result interface{}
+	Error  error
+}
+
+// Batch sends calls in one request,the error of each call is set in it.
+// The returned error is set when the batch could not be sent.
+func (c *Client) Batch(ctx context.Context, calls []*BatchCall) error {
+	requests := make([]request, len(calls))
+	pendings := make([]*pendingCall, len(calls))
+	for i, call := range calls {
+		id := atomic.AddUint64(&c.nextID, 1)
+		p, err := c.register(id, nil)
+		if err != nil {
+			return err
+		}
+		defer c.unregister(id)
+		requests[i] = request{Version: "2.0", Method: call.Method, Params: call.Params, Id: &id}
+		pendings[i] = p
+	}
+	body, err := json.Marshal(requests)
+	if err != nil {
+		return err
+	}
+	if err = c.transport.send(ctx, body, c.deliver); err != nil {
+		return err
+	}
+	for i, call := range calls {
+		call.Error = c.wait(ctx, pendings[i], call.Result)
+	}
+	return nil
+}
+
+// Close closes the client,calls in flight fail with ErrClosed.
+func (c *Client) Close() error {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.mutex.Unlock()
+	err := c.transport.close()
+	c.failAll(ErrClosed)
+	return err
+}
+
+func (c *Client) register(id uint64, progress ProgressFunc) (*pendingCall, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return nil, ErrClosed
+	}
+	p := &pendingCall{done: make(chan *response, 1), progress: progress}
+	c.pending[strconv.FormatUint(id, 10)] = p
+	return p, nil
+}
+
+func (c *Client) unregister(id uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.pending, strconv.FormatUint(id, 10))
+}
+
+// wait waits for the response of p and decodes its result.
+func (c *Client) wait(ctx context.Context, p *pendingCall, result interface{}) error {
+	select {
+	case r := <-p.done:
+		if r.err != nil {
+			return r.err
+		}
+		if r.Error != nil {
+			return r.Error
+		}
+		if result == nil || r.Result == nil {
+			return nil
+		}
+		return json.Unmarshal(*r.Result, result)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// failAll fails the calls in flight with err.
+func (c *Client) failAll(err error) {
+	c.failUpTo(math.MaxUint64, err)
+}
+
+// failUpTo fails the calls in flight with ids up to id with err.
+func (c *Client) failUpTo(id uint64, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for k, p := range c.pending {
+		if n, _ := strconv.ParseUint(k, 10, 64); n > id {
+			continue
+		}
+		select {
+		case p.done <- &response{err: err}:
+		default:
+		}
+	}
+}
+
+// disconnected fails the calls in flight,or keeps them for the session to
+// resume.
+func (c *Client) disconnected() {
+	if !c.resume {
+		c.failAll(ErrDisconnected)
+		return
+	}
+	c.mutex.Lock()
+	c.lost = atomic.LoadUint64(&c.nextID)
+	c.mutex.Unlock()
+}
+
+// resumed handles the rpc.SessionMethod notification which starts a
+// connection,the calls kept since the loss fail if the session is new.
+func (c *Client) resumed(params json.RawMessage) {
+	var session struct {
+		Session string `json:"session"`
+		Resumed bool   `json:"resumed"`
+	}
+	json.Unmarshal(params, &session)
+	c.mutex.Lock()
+	c.session = session.Session
+	lost := c.lost
+	c.lost = 0
+	c.mutex.Unlock()
+	if lost > 0 && !session.Resumed {
+		c.failUpTo(lost, ErrDisconnected)
+	}
+}
+
+// dialURL is the url of the websocket with the session to resume.
+func (c *Client) dialURL() string {
+	if !c.resume {
+		return c.url
+	}
+	c.mutex.Lock()
+	session := c.session
+	c.mutex.Unlock()
+	if session == "" {
+		session = "new"
+	}
+	u, err := url.Parse(c.url)
+	if err != nil {
+		return c.url
+	}
+	q := u.Query()
+	q.Set("session", session)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// deliver passes the responses and notifications in data to their receivers.
+func (c *Client) deliver(data []byte) {
+	var messages []*response
+	if err := json.Unmarshal(data, &messages); err != nil {
+		r := new(response)
+		if err = json.Unmarshal(data, r); err != nil {
+			return
+		}
+		messages = []*response{r}
+	}
+	for _, r := range messages {
+		if r.Method != "" && r.Id != nil {
+			go c.answer(r)
+			continue
+		}
+		if r.Method != "" && r.Result == nil && r.Error == nil {
+			c.notification(r)
+			continue
+		}
+		if r.Id == nil {
+			continue
+		}
+		c.mutex.Lock()
+		p := c.pending[string(*r.Id)]
+		c.mutex.Unlock()
+		if p != nil {
+			select {
+			case p.done <- r:
+			default:
+			}
+		}
+	}
+}
+
+// answer sends the response to a request of the agent.
+func (c *Client) answer(r *response) {
+	reply := map[string]interface{}{"jsonrpc": "2.0", "id": r.Id}
+	var result interface{}
+	var err error
+	if c.onRequest == nil {
+		err = &Error{Code: int(rpc.E_NO_METHOD), Message: "client: can't find method " + strconv.Quote(r.Method)}
+	} else {
+		result, err = c.onRequest(r.Method, r.Params)
+	}
+	if err != nil {
+		e, ok := err.(*Error)
+		if !ok {
+			e = &Error{Code: int(rpc.E_SERVER), Message: err.Error()}
+		}
+		reply["error"] = e
+	} else {
+		reply["result"] = result
+	}
+	body, err := json.Marshal(reply)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	c.transport.send(ctx, body, c.deliver)
+}
+
+// notification passes progress to the call it belongs to,and other
+// notifications to the handler.
+func (c *Client) notification(r *response) {
+	if r.Method == rpc.SessionMethod && c.resume {
+		c.resumed(r.Params)
+		return
+	}
+	if r.Method == rpc.ProgressMethod {
+		var progress struct {
+			Id       json.RawMessage `json:"id"`
+			Progress json.RawMessage `json:"progress"`
+		}
+		if json.Unmarshal(r.Params, &progress) == nil {
+			c.mutex.Lock()
+			p := c.pending[string(progress.Id)]
+			c.mutex.Unlock()
+			if p != nil && p.progress != nil {
+				p.progress(progress.Progress)
+			}
+		}
+		return
+	}
+	if c.onNotify != nil {
+		c.onNotify(r.Method, r.Params)
+	}
+}
@@ -0,0 +1,78 @@
+package client_test
+
+import (
+	"agentX/client"
+	"agentX/plugins/systemx"
+	"agentX/rpc"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testService struct{}
+
+func (x *testService) Ask(ctx *rpc.Context, message *string, reply *bool) (err error) {
+	*reply, err = ctx.Confirm(*message, time.Second*5)
+	return
+}
+
+func (x *testService) Fail(reply *int) error {
+	return rpc.NewError(rpc.E_BAD_PARAMS, "bad", nil)
+}
+
+// newAgent returns the http and ws urls of a test agent.
+func newAgent(t *testing.T) (httpURL, wsURL string) {
+	s, err := rpc.New(rpc.WithService(new(systemx.SystemX), "system"), rpc.WithService(new(testService), "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(func() {
+		ts.Close()
+		s.Close(context.Background())
+	})
+	return ts.URL + "/token", "ws" + strings.TrimPrefix(ts.URL, "http") + "/token"
+}
+
+func TestClient(t *testing.T) {
+	httpURL, wsURL := newAgent(t)
+	confirm := client.WithRequestHandler(func(method string, params json.RawMessage) (interface{}, error) {
+		return method == rpc.ConfirmMethod, nil
+	})
+	for _, url := range []string{httpURL, wsURL} {
+		c, err := client.Dial(url, confirm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		r, err := c.System("").Exec(ctx, systemx.Command{Cmd: "echo hi; exit 2"})
+		if err != nil || r.Stdout != "hi\n" || r.ExitCode != 2 {
+			t.Errorf("%s : Exec = %+v,%v", url, r, err)
+		}
+		err = c.Call(ctx, "test.Fail", nil, nil)
+		if e, ok := err.(*client.Error); !ok || e.Code != int(rpc.E_BAD_PARAMS) || e.Message != "bad" {
+			t.Errorf("%s : Fail = %#v", url, err)
+		}
+		calls := []*client.BatchCall{
+			{Method: "system.Exec", Params: systemx.Command{Cmd: "echo a"}, Result: new(systemx.Result)},
+			{Method: "test.Nope"},
+		}
+		if err = c.Batch(ctx, calls); err != nil || calls[0].Error != nil || calls[0].Result.(*systemx.Result).Stdout != "a\n" || calls[1].Error == nil {
+			t.Errorf("%s : Batch = %v,%v,%v", url, err, calls[0].Error, calls[1].Error)
+		}
+		// the agent can only call the client over websocket
+		var ok bool
+		err = c.Call(ctx, "test.Ask", "sure?", &ok)
+		if url == wsURL && (err != nil || !ok) || url == httpURL && err == nil {
+			t.Errorf("%s : Ask = %v,%v", url, ok, err)
+		}
+		cancel()
+		c.Close()
+		if err = c.Call(context.Background(), "system.Time", nil, nil); err != client.ErrClosed {
+			t.Errorf("%s : call after Close = %v", url, err)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"agentX/plugins/gitx"
+	"agentX/plugins/systemx"
+	"context"
+)
+
+// Git calls the git service,name is the service name of the git plugin
+// on the agent,"git" if empty.
+func (c *Client) Git(name string) *Git {
+	if name == "" {
+		name = "git"
+	}
+	return &Git{c: c, name: name}
+}
+
+// Git is the typed client of the git service.
+type Git struct {
+	c    *Client
+	name string
+}
+
+// Publish checks out the repository of url and returns the output.
+func (g *Git) Publish(ctx context.Context, url gitx.URL) (output string, err error) {
+	err = g.c.Call(ctx, g.name+".Publish", url, &output)
+	return
+}
+
+// System calls the system service,name is the service name of the system
+// plugin on the agent,"system" if empty.
+func (c *Client) System(name string) *System {
+	if name == "" {
+		name = "system"
+	}
+	return &System{c: c, name: name}
+}
+
+// System is the typed client of the system service.
+type System struct {
+	c    *Client
+	name string
+}
+
+// Time returns the unix time of the agent.
+func (s *System) Time(ctx context.Context) (now string, err error) {
+	err = s.c.Call(ctx, s.name+".Time", nil, &now)
+	return
+}
+
+// Passwd returns /etc/passwd of the agent.
+func (s *System) Passwd(ctx context.Context) (passwd string, err error) {
+	err = s.c.Call(ctx, s.name+".Passwd", nil, &passwd)
+	return
+}
+
+// Exec runs cmd on the agent and returns its output and exit status,an
+// async command returns only its pid.
+func (s *System) Exec(ctx context.Context, cmd systemx.Command) (result systemx.Result, err error) {
+	err = s.c.Call(ctx, s.name+".Exec", cmd, &result)
+	return
+}
@@ -0,0 +1,175 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// httpTransport posts each request and delivers the response body.
+type httpTransport struct {
+	c *Client
+}
+
+func (t *httpTransport) send(ctx context.Context, body []byte, deliver func([]byte)) error {
+	req, err := http.NewRequest("POST", t.c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range t.c.header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("client: %s : %s", resp.Status, bytes.TrimSpace(data))
+	}
+	deliver(data)
+	return nil
+}
+
+func (t *httpTransport) close() error {
+	return nil
+}
+
+// wsTransport keeps a websocket to the agent,requests are sent as text
+// messages and the read loop delivers what the agent sends. When the
+// connection is lost the calls in flight fail with ErrDisconnected,unless
+// the session is resumed,and it reconnects with backoff,sends wait for the
+// connection meanwhile.
+type wsTransport struct {
+	c      *Client
+	dialer *websocket.Dialer
+
+	writeLock sync.Mutex
+	mutex     sync.Mutex
+	conn      *websocket.Conn
+	ready     chan bool // closed when conn is set
+	stop      chan bool
+	closed    bool
+}
+
+func dialWS(c *Client) (t *wsTransport, err error) {
+	t = &wsTransport{
+		c:      c,
+		dialer: &websocket.Dialer{Proxy: http.ProxyFromEnvironment, HandshakeTimeout: time.Second * 10},
+		ready:  make(chan bool),
+		stop:   make(chan bool),
+	}
+	conn, _, err := t.dialer.Dial(c.dialURL(), c.header)
+	if err != nil {
+		return nil, err
+	}
+	t.setConn(conn)
+	go t.run(conn)
+	return
+}
+
+func (t *wsTransport) setConn(conn *websocket.Conn) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.conn = conn
+	close(t.ready)
+}
+
+// run reads conn until it is lost and reconnects until close.
+func (t *wsTransport) run(conn *websocket.Conn) {
+	for {
+		t.read(conn)
+		t.mutex.Lock()
+		t.conn = nil
+		t.ready = make(chan bool)
+		closed := t.closed
+		t.mutex.Unlock()
+		if closed {
+			return
+		}
+		t.c.disconnected()
+		interval := t.c.backoffMin
+		for {
+			select {
+			case <-t.stop:
+				return
+			case <-time.After(interval):
+			}
+			var err error
+			if conn, _, err = t.dialer.Dial(t.c.dialURL(), t.c.header); err == nil {
+				break
+			}
+			if interval *= 2; interval > t.c.backoffMax {
+				interval = t.c.backoffMax
+			}
+		}
+		t.setConn(conn)
+	}
+}
+
+// read delivers the lines of the messages of conn until it fails.
+func (t *wsTransport) read(conn *websocket.Conn) {
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			conn.Close()
+			return
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(message))
+		scanner.Buffer(nil, len(message)+1)
+		for scanner.Scan() {
+			if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+				t.c.deliver(append([]byte{}, line...))
+			}
+		}
+	}
+}
+
+func (t *wsTransport) send(ctx context.Context, body []byte, deliver func([]byte)) error {
+	for {
+		t.mutex.Lock()
+		conn, ready, closed := t.conn, t.ready, t.closed
+		t.mutex.Unlock()
+		if closed {
+			return ErrClosed
+		}
+		if conn != nil {
+			t.writeLock.Lock()
+			defer t.writeLock.Unlock()
+			return conn.WriteMessage(websocket.TextMessage, append(body, '\n'))
+		}
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (t *wsTransport) close() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	close(t.stop)
+	if t.conn != nil {
+		t.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		return t.conn.Close()
+	}
+	return nil
+}
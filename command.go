@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/pflag"
+)
+
+//commandFlags adds the flags of a sub command to the global flags
+//when it is the first arg which is not a global flag,see firstArg
+var commandFlags = map[string]func(fs *pflag.FlagSet){
+	"test-controller": testControllerFlags,
+	"controller":      controllerFlags,
+	"gen":             genFlags,
+	"call":            cliFlags,
+	"shell":           cliFlags,
+}
+
+//runCommand runs the sub command in args and returns the exit code
+func runCommand(args []string) int {
+	switch args[0] {
+	case "config":
+		return configCommand(args[1:])
+	case "call":
+		return callCommand(args[1:])
+	case "shell":
+		return shellCommand(args[1:])
+	case "gen":
+		return genCommand(args[1:])
+	case "controller":
+		return controllerCommand(args[1:])
+	case "test-controller":
+		return testControllerCommand(args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+	return 2
+}
+
+//configCommand is `agentx config check|print`
+func configCommand(args []string) int {
+	if len(args) != 1 || (args[0] != "check" && args[0] != "print") {
+		fmt.Fprintln(os.Stderr, "usage: agentx config check|print [--config file]")
+		return 2
+	}
+	c, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if args[0] == "check" {
+		if c.file != "" {
+			fmt.Printf("config file : %s\n", c.file)
+		}
+		for _, f := range c.includes {
+			fmt.Printf("include config file : %s\n", f)
+		}
+		fmt.Println("config ok")
+		return 0
+	}
+	fmt.Print(c.toml())
+	return 0
+}
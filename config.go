@@ -3,68 +3,341 @@ package main
 import (
 	"flag"
 	"fmt"
-
 	"os"
-
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/fatih/color"
+	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
 var (
-	cfg = viper.New()
+	//configFile is the --config flag
+	configFile *string
+	//activeCfg holds the *Config in use,it is swapped as a whole on reload
+	activeCfg atomic.Value
+	//reloadLock serializes reloads coming from the watcher and the admin rpc
+	reloadLock sync.Mutex
+	//reloadHooks apply a reloaded config before it becomes active
+	reloadHooks []func(old, new *Config) error
+	//restartKeys can not be changed at runtime,they map to the reason,
+	//a key ending with a dot is a whole section and * is any part of a key
+	restartKeys = map[string]string{
+		"rpc.listen":          "the listener is opened at start",
+		"rpc.methodNames":     "method names are mapped when services register",
+		"rpc.defaultVersion.": "default versions are set when services register",
+		"rpc.unix.path":       "the socket is created at start",
+		"rpc.unix.mode":       "the socket is created at start",
+		"rpc.unix.group":      "the socket is created at start",
+		"rpc.tcp.":            "the listener and its certificate are loaded at start",
+		"rpc.console":         "the console handler is mounted at start",
+		"rpc.rest":            "the rest routes are mounted at start",
+		"rpc.compression.":    "the compressing handler and listeners are set up at start",
+		"reverse.":            "the controller link is dialed with it at start",
+		"register.":           "the registration loop is started with it",
+		"log.dir":             "log files are opened at start",
+		"log.fileMaxSize":     "log files are opened at start",
+		"log.maxCount":        "log files are opened at start",
+		"plugins.*.enabled":   "plugins are registered at start",
+		"plugins.*.name":      "plugins are registered at start",
+		"plugins.*.version":   "plugins are registered at start",
+		"plugins.*.aliases.":  "plugins are registered at start",
+		"plugins.*.command":   "the plugin process is started with it",
+		"plugins.*.dir":       "the plugin process is started with it",
+		"plugins.*.timeout":   "the plugin process is started with it",
+	}
 )
 
-func initConfig() (err error) {
-	cfg.SetDefault("agentX.version", "1.0")
+const version = "1.0"
+
+//parseFlags parses cli flags,the remaining args are a sub command
+func parseFlags() {
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
 	//cli&default config
-	configFile := pflag.String("config", "", "config file path")
-	version := pflag.Bool("version", false, "show version")
-	if *version {
-		fmt.Printf("agentX v%s - https://github.com/snail007/agentX\n", cfg.GetString("agentX.version"))
-		os.Exit(0)
-	}
+	configFile = pflag.String("config", "", "config file path")
+	showVersion := pflag.Bool("version", false, "show version")
 	pflag.String("rpc-listen", ":9091", "the api port to listen")
 	pflag.String("level", "debug", "log level to show in console")
 	pflag.String("log-dir", "log", "the directory which store log files")
 	pflag.Int64("log-max-size", 102400000, "log file max size(bytes) for rotate")
 	pflag.Int("log-max-count", 3, "log file max count for rotate to remain")
 	pflag.StringSlice("log-level", []string{"info", "error", "debug"}, "log to file level,multiple splitted by comma(,)")
+	if add := commandFlags[firstArg(pflag.CommandLine, os.Args[1:])]; add != nil {
+		add(pflag.CommandLine)
+	}
 	pflag.Parse()
+	if *showVersion {
+		fmt.Printf("agentX v%s - https://github.com/snail007/agentX\n", version)
+		os.Exit(0)
+	}
+}
 
-	//bind flag
-	cfg.BindPFlag("rpc.listen", pflag.Lookup("rpc-listen"))
-	cfg.BindPFlag("log.dir", pflag.Lookup("log-dir"))
-	cfg.BindPFlag("log.level", pflag.Lookup("log-level"))
-	cfg.BindPFlag("log.console-level", pflag.Lookup("level"))
-	cfg.BindPFlag("log.fileMaxSize", pflag.Lookup("log-max-size"))
-	cfg.BindPFlag("log.maxCount", pflag.Lookup("log-max-count"))
-	if *configFile != "" {
-		cfg.SetConfigFile(*configFile)
-	} else {
-		cfg.SetConfigName("config")
-		cfg.AddConfigPath("/etc/agentx/")
-		cfg.AddConfigPath("$HOME/.agentx")
-		cfg.AddConfigPath(".agentx")
-		cfg.AddConfigPath(".")
-	}
-	err = cfg.ReadInConfig()
-	file := cfg.ConfigFileUsed()
-	if err != nil && !strings.Contains(fmt.Sprintf("%s", err.Error()), "Not") {
-		fmt.Printf("%s", err)
-	} else if file != "" {
-		fmt.Printf("use config file : %s\n", file)
+//firstArg returns the first arg which is not a flag of fs or its value,
+//the sub command
+func firstArg(fs *pflag.FlagSet, args []string) string {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			if i+1 < len(args) {
+				return args[i+1]
+			}
+			return ""
+		case len(arg) < 2 || arg[0] != '-':
+			return arg
+		case strings.Contains(arg, "="):
+			continue
+		}
+		var f *pflag.Flag
+		if strings.HasPrefix(arg, "--") {
+			f = fs.Lookup(arg[2:])
+		} else {
+			f = fs.ShorthandLookup(arg[len(arg)-1:])
+		}
+		//the next arg is the value of a flag which is not a bool
+		if f != nil && f.NoOptDefVal == "" {
+			i++
+		}
+	}
+	return ""
+}
+
+func initConfig() (err error) {
+	c, err := loadConfig(*configFile)
+	if err != nil {
+		return
+	}
+	if c.file != "" {
+		fmt.Printf("use config file : %s\n", c.file)
+	}
+	for _, f := range c.includes {
+		fmt.Printf("include config file : %s\n", f)
 	}
 	setInternalConfig()
+	activeCfg.Store(c)
 	return
 }
 
+//loadConfig merges,in order of increasing priority,defaults,the config file,
+//conf.d/*.toml beside the config file,AGENTX_* environment variables and cli flags.
+//file is searched in the default paths when empty.
+func loadConfig(file string) (c *Config, err error) {
+	v := viper.New()
+	v.SetDefault("agentX.version", version)
+	v.SetDefault("agentX.shutdownTimeout", 10)
+	v.SetDefault("rpc.methodNames", "exact")
+	v.SetDefault("rpc.readLimit", 32*1024*1024)
+	v.SetDefault("rpc.console", "/console/")
+	v.SetDefault("rpc.compression.http", true)
+	v.SetDefault("rpc.compression.websocket", true)
+	v.SetDefault("rpc.compression.threshold", 1024)
+	v.SetDefault("rpc.compression.level", -1)
+	v.SetDefault("rpc.websocket.ping", 30)
+	v.SetDefault("rpc.websocket.readTimeout", 75)
+	v.SetDefault("rpc.websocket.writeTimeout", 10)
+	v.SetDefault("rpc.websocket.sessionTTL", 120)
+	v.SetDefault("rpc.websocket.sessionBuffer", 1000)
+	v.SetDefault("rpc.unix.mode", "0660")
+	v.SetDefault("rpc.unix.trustedUsers", []string{"root"})
+	v.SetDefault("reverse.heartbeat", 15)
+	v.SetDefault("register.interval", 300)
+	v.SetDefault("register.idFile", "agentx.id")
+	bindConfig(v)
+	if file != "" {
+		v.SetConfigFile(file)
+	} else {
+		v.SetConfigName("config")
+		v.AddConfigPath("/etc/agentx/")
+		v.AddConfigPath("$HOME/.agentx")
+		v.AddConfigPath(".agentx")
+		v.AddConfigPath(".")
+	}
+	c = new(Config)
+	if err = v.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			err = fmt.Errorf("config error : %s : %s", v.ConfigFileUsed(), err)
+			return
+		}
+		err = nil
+	}
+	c.file = v.ConfigFileUsed()
+	if c.file != "" {
+		c.includes, _ = filepath.Glob(filepath.Join(includeDir(c.file), "*.toml"))
+		sort.Strings(c.includes)
+		for _, f := range c.includes {
+			var fd *os.File
+			if fd, err = os.Open(f); err != nil {
+				err = fmt.Errorf("config error : %s", err)
+				return
+			}
+			v.SetConfigType("toml")
+			err = v.MergeConfig(fd)
+			fd.Close()
+			if err != nil {
+				err = fmt.Errorf("config error : %s : %s", f, err)
+				return
+			}
+		}
+	}
+	errs := decodeConfig(v, c)
+	//the id should not change with the working directory
+	if c.file != "" && c.Register.IDFile != "" && !filepath.IsAbs(c.Register.IDFile) {
+		c.Register.IDFile = filepath.Join(filepath.Dir(c.file), c.Register.IDFile)
+	}
+	if len(errs) == 0 {
+		errs = c.validate()
+	}
+	if len(errs) > 0 {
+		err = fmt.Errorf("config error :\n\t%s", strings.Join(errs, "\n\t"))
+	}
+	return
+}
+
+//includeDir is the conf.d directory beside file
+func includeDir(file string) string {
+	return filepath.Join(filepath.Dir(file), "conf.d")
+}
+
 func setInternalConfig() {
 
 }
+
+//conf returns the active config
+func conf() *Config {
+	return activeCfg.Load().(*Config)
+}
+
+//onReload registers fn to apply a reloaded config before it becomes active,
+//a fn which fails should leave its part as it was,the hooks called before
+//it are called again with the configs swapped to roll back
+func onReload(fn func(old, new *Config) error) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+	reloadHooks = append(reloadHooks, fn)
+}
+
+//reloadConfig reads the config files into a new config,validates it and
+//activates it,the previous config stays active when anything fails.
+func reloadConfig() (changes []string, err error) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+	old := conf()
+	if old.file == "" {
+		err = fmt.Errorf("no config file in use")
+		return
+	}
+	c, err := loadConfig(old.file)
+	if err != nil {
+		err = fmt.Errorf("config reload fail,keep previous config : %s", err)
+		log.Error(err)
+		return
+	}
+	for i, fn := range reloadHooks {
+		if err = fn(old, c); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				if e := reloadHooks[j](c, old); e != nil {
+					log.Errorf("config reload : roll back fail : %s", e)
+				}
+			}
+			err = fmt.Errorf("config reload fail,keep previous config : %s", err)
+			log.Error(err)
+			return
+		}
+	}
+	changes = old.diff(c)
+	activeCfg.Store(c)
+	if len(changes) == 0 {
+		log.Infof("config reloaded from %s,nothing changed", c.file)
+		return
+	}
+	log.Infof("config reloaded from %s,%d change(s) : %s", c.file, len(changes), strings.Join(changes, " , "))
+	for _, change := range changes {
+		key := strings.TrimLeft(change, "+-")
+		if i := strings.IndexAny(key, ":="); i > 0 {
+			key = key[:i]
+		}
+		for k, reason := range restartKeys {
+			if matchKey(k, key) {
+				log.Warnf("config %s changed,it takes effect after restart,%s", key, reason)
+			}
+		}
+	}
+	return
+}
+
+//matchKey returns true if key matches pattern,a key of restartKeys
+func matchKey(pattern, key string) bool {
+	section := strings.HasSuffix(pattern, ".")
+	patternParts := strings.Split(strings.TrimSuffix(pattern, "."), ".")
+	keyParts := strings.Split(key, ".")
+	if len(keyParts) < len(patternParts) || (!section && len(keyParts) != len(patternParts)) || (section && len(keyParts) == len(patternParts)) {
+		return false
+	}
+	for i, part := range patternParts {
+		if part != "*" && part != keyParts[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//watchConfig reloads config when the config file or conf.d changes.
+//the directories are watched to pick up editors which save by rename.
+func watchConfig() (err error) {
+	file := conf().file
+	if file == "" {
+		return
+	}
+	file, _ = filepath.Abs(file)
+	include := includeDir(file)
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return
+	}
+	if err = watcher.Add(filepath.Dir(file)); err != nil {
+		watcher.Close()
+		return
+	}
+	//conf.d is optional
+	watcher.Add(include)
+	go func() {
+		defer watcher.Close()
+		var timer *time.Timer
+		for {
+			select {
+			case event := <-watcher.Events:
+				name, _ := filepath.Abs(event.Name)
+				if name == include && event.Op&fsnotify.Create != 0 {
+					watcher.Add(include)
+					continue
+				}
+				if name != file && !(filepath.Dir(name) == include && filepath.Ext(name) == ".toml") {
+					continue
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 {
+					continue
+				}
+				//editors emit several events for one save
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(time.Millisecond*300, func() {
+					reloadConfig()
+				})
+			case err := <-watcher.Errors:
+				log.Warnf("config watcher : %s", err)
+			}
+		}
+	}()
+	return
+}
+
 func poster() string {
 	fg := color.New(color.FgHiYellow).SprintFunc()
 	return fg(`
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	logger "github.com/snail007/mini-logger"
+	"github.com/spf13/pflag"
+)
+
+var flagsOnce sync.Once
+
+//testConfig writes the files in a temp dir and loads config.toml,the cli
+//flags are the defaults
+func testConfig(t *testing.T, files map[string]string) (*Config, error) {
+	return testConfigIn(t, t.TempDir(), files)
+}
+
+//testConfigIn is testConfig writing the files in dir
+func testConfigIn(t *testing.T, dir string, files map[string]string) (*Config, error) {
+	flagsOnce.Do(func() {
+		args := os.Args
+		os.Args = args[:1]
+		parseFlags()
+		os.Args = args
+	})
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(file), 0755)
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return loadConfig(filepath.Join(dir, "config.toml"))
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		check func(c *Config) bool
+		err   string //a part of the error,empty if it loads
+	}{
+		{"defaults", map[string]string{"config.toml": ""}, func(c *Config) bool {
+			return c.RPC.Listen == ":9091" && c.RPC.ReadLimit == 32*1024*1024 && c.Log.ConsoleLevel == "debug" && c.RPC.WebSocket.Ping == 30 &&
+				c.Register.IDFile == filepath.Join(filepath.Dir(c.file), "agentx.id")
+		}, ""},
+		{"conf.d", map[string]string{
+			"config.toml":        "[rpc]\nlisten = \":1\"\nreadLimit = 10\n",
+			"conf.d/b.toml":      "[rpc]\nreadLimit = 30\n",
+			"conf.d/a.toml":      "[rpc]\nreadLimit = 20\nrest = true\n",
+			"conf.d/ignored.txt": "[rpc]\nreadLimit = 40\n",
+		}, func(c *Config) bool {
+			return c.RPC.Listen == ":1" && c.RPC.ReadLimit == 30 && c.RPC.REST && len(c.includes) == 2
+		}, ""},
+		{"id file", map[string]string{"config.toml": "[register]\nidFile = \"state/id\"\n"}, func(c *Config) bool {
+			return c.Register.IDFile == filepath.Join(filepath.Dir(c.file), "state", "id")
+		}, ""},
+		{"absolute id file", map[string]string{"config.toml": "[register]\nidFile = \"/var/lib/agentx/id\"\n"}, func(c *Config) bool {
+			return c.Register.IDFile == "/var/lib/agentx/id"
+		}, ""},
+		{"unknown key", map[string]string{"config.toml": "[rpc]\nlisen = \":1\"\n"}, nil, "rpc.lisen"},
+		{"bad type", map[string]string{"config.toml": "[rpc]\nreadLimit = \"x\"\n"}, nil, "rpc.readLimit"},
+		{"bad listen", map[string]string{"config.toml": "[rpc]\nlisten = \"9091\"\n"}, nil, "rpc.listen : "},
+		{"bad ping", map[string]string{"config.toml": "[rpc.websocket]\nping = 80\n"}, nil, "rpc.websocket.ping : "},
+		{"bad version", map[string]string{"config.toml": "[rpc.defaultVersion]\ngit = \"1\"\n"}, nil, "rpc.defaultVersion.git : "},
+		{"bad plugin setting", map[string]string{"config.toml": "[plugins.system]\nmaxOutput = -1\n"}, nil, "plugins.system : "},
+		{"unknown plugin", map[string]string{"config.toml": "[plugins.nope]\nenabled = true\n"}, nil, "plugins.nope : unknown plugin"},
+		{"bad toml", map[string]string{"config.toml": "[rpc\n"}, nil, "config error : "},
+	}
+	for _, test := range tests {
+		c, err := testConfig(t, test.files)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s : got error %v,want %q in it", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s : %s", test.name, err)
+			continue
+		}
+		if !test.check(c) {
+			t.Errorf("%s : unexpected config %+v", test.name, c)
+		}
+	}
+}
+
+func TestConfigDiff(t *testing.T) {
+	dir := t.TempDir()
+	old, err := testConfigIn(t, dir, map[string]string{"config.toml": "[rpc]\ntokens = [\"a\"]\nreadLimit = 10\n[plugins.git]\nenabled = false\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		config  string
+		changes []string
+	}{
+		{"[rpc]\ntokens = [\"a\"]\nreadLimit = 10\n[plugins.git]\nenabled = false\n", nil},
+		{"[rpc]\ntokens = [\"b\"]\nreadLimit = 20\n[plugins.git]\nenabled = false\n", []string{
+			`rpc.readLimit: 10 -> 20`,
+			`rpc.tokens: "******" -> "******"`,
+		}},
+		{"[rpc]\ntokens = [\"a\"]\nreadLimit = 10\n[plugins.git]\nenabled = false\nname = \"g\"\n", []string{
+			`+plugins.git.name="g"`,
+		}},
+		{"[rpc]\ntokens = [\"a\"]\nreadLimit = 10\n", []string{
+			`-plugins.git.enabled`,
+		}},
+	}
+	for _, test := range tests {
+		c, err := testConfigIn(t, dir, map[string]string{"config.toml": test.config})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changes := old.diff(c); strings.Join(changes, "|") != strings.Join(test.changes, "|") {
+			t.Errorf("%q : got %q,want %q", test.config, changes, test.changes)
+		}
+	}
+	if toml := old.toml(); strings.Contains(toml, `"a"`) || !strings.Contains(toml, `tokens = "******"`) {
+		t.Errorf("toml shows the token :\n%s", toml)
+	}
+}
+
+func TestMatchKey(t *testing.T) {
+	tests := []struct {
+		pattern, key string
+		match        bool
+	}{
+		{"rpc.listen", "rpc.listen", true},
+		{"rpc.listen", "rpc.listenx", false},
+		{"rpc.listen", "rpc.listen.x", false},
+		{"rpc.tcp.", "rpc.tcp.listen", true},
+		{"rpc.tcp.", "rpc.tcp", false},
+		{"rpc.tcp.", "rpc.tcpx.listen", false},
+		{"plugins.*.enabled", "plugins.git.enabled", true},
+		{"plugins.*.enabled", "plugins.git.settings.enabled", false},
+		{"plugins.*.aliases.", "plugins.git.aliases.pub", true},
+		{"plugins.*.aliases.", "plugins.git.settings.x", false},
+	}
+	for _, test := range tests {
+		if got := matchKey(test.pattern, test.key); got != test.match {
+			t.Errorf("matchKey(%q,%q) = %v,want %v", test.pattern, test.key, got, test.match)
+		}
+	}
+}
+
+func TestFirstArg(t *testing.T) {
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	fs.String("level", "debug", "")
+	fs.StringP("config", "c", "", "")
+	fs.Bool("version", false, "")
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, ""},
+		{[]string{"call", "x"}, "call"},
+		{[]string{"--level", "info", "call"}, "call"},
+		{[]string{"--level=info", "call"}, "call"},
+		{[]string{"-c", "a.toml", "gen"}, "gen"},
+		{[]string{"--version", "call"}, "call"},
+		{[]string{"--unknown", "call"}, "call"},
+		{[]string{"--", "--level"}, "--level"},
+		{[]string{"--level"}, ""},
+		{[]string{"-", "call"}, "-"},
+	}
+	for _, test := range tests {
+		if got := firstArg(fs, test.args); got != test.want {
+			t.Errorf("firstArg(%q) = %q,want %q", test.args, got, test.want)
+		}
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	if log == nil {
+		log = logger.New(false, nil)
+	}
+	tests := []struct {
+		name  string
+		fail  int      //the hook which fails,-1 if none
+		calls []string //the readLimit changes the hooks see
+		limit int64    //the readLimit active after the reload
+	}{
+		{"applied", -1, []string{"0:10->20", "1:10->20"}, 20},
+		{"second hook fails", 1, []string{"0:10->20", "1:10->20", "0:20->10"}, 10},
+		{"first hook fails", 0, []string{"0:10->20"}, 10},
+	}
+	hooks := reloadHooks
+	defer func() {
+		reloadHooks = hooks
+	}()
+	for _, test := range tests {
+		c, err := testConfig(t, map[string]string{"config.toml": "[rpc]\nreadLimit = 10\n"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		activeCfg.Store(c)
+		calls := []string{}
+		reloadHooks = nil
+		for i := 0; i < 2; i++ {
+			i := i
+			reloadHooks = append(reloadHooks, func(old, new *Config) error {
+				calls = append(calls, fmt.Sprintf("%d:%d->%d", i, old.RPC.ReadLimit, new.RPC.ReadLimit))
+				if i == test.fail {
+					return fmt.Errorf("hook %d fail", i)
+				}
+				return nil
+			})
+		}
+		if err = ioutil.WriteFile(c.file, []byte("[rpc]\nreadLimit = 20\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err = reloadConfig()
+		if (err != nil) != (test.fail >= 0) || strings.Join(calls, " ") != strings.Join(test.calls, " ") || conf().RPC.ReadLimit != test.limit {
+			t.Errorf("%s : got %v,calls %q,readLimit %d,want calls %q,readLimit %d", test.name, err, calls, conf().RPC.ReadLimit, test.calls, test.limit)
+		}
+	}
+}
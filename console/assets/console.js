@@ -0,0 +1,496 @@
+// agentX console,speaks newline-delimited json-rpc 2.0 over the websocket
+// of the agent it is served by.
+(function() {
+    "use strict";
+
+    var maxJobs = 200,
+        maxEvents = 500;
+
+    var ws = null,
+        nextId = 1,
+        jobs = {}, // by id
+        jobOrder = [],
+        selected = null, // id of the job shown in detail
+        events = [];
+
+    function $(id) {
+        return document.getElementById(id);
+    }
+
+    // el creates an element with props and children,strings are text
+    function el(tag, props, children) {
+        var e = document.createElement(tag);
+        Object.keys(props || {}).forEach(function(k) {
+            e[k] = props[k];
+        });
+        (children || []).forEach(function(c) {
+            e.appendChild(typeof c === "string" ? document.createTextNode(c) : c);
+        });
+        return e;
+    }
+
+    function pretty(v) {
+        return JSON.stringify(v, null, 2);
+    }
+
+    function clock(t) {
+        return t.toTimeString().substr(0, 8);
+    }
+
+    function setStatus(text, cls) {
+        var s = $("status");
+        s.textContent = text;
+        s.className = "status " + (cls || "");
+    }
+
+    // connection
+
+    function connect() {
+        if (ws) {
+            ws.onclose = null;
+            ws.close();
+            lost();
+        }
+        var token = $("token").value;
+        sessionStorage.setItem("agentx.token", token);
+        var scheme = location.protocol === "https:" ? "wss://" : "ws://";
+        ws = new WebSocket(scheme + location.host + "/" + encodeURIComponent(token || "none"));
+        setStatus("connecting");
+        var opened = false;
+        ws.onopen = function() {
+            opened = true;
+            setStatus("connected", "ok");
+            send("rpc.discover", null, true, function(job) {
+                if (job.error) {
+                    setStatus("discover fail : " + job.error.message, "error");
+                    return;
+                }
+                showServices(job.result || []);
+            });
+            send("rpc.subscribe", { topics: ["*"] }, true, function(job) {
+                if (job.error) {
+                    addEvent("rpc.subscribe fail", job.error);
+                }
+            });
+        };
+        ws.onmessage = function(e) {
+            String(e.data).split("\n").forEach(function(line) {
+                if (line) {
+                    receive(line);
+                }
+            });
+        };
+        ws.onclose = function() {
+            ws = null;
+            setStatus(opened ? "disconnected" : "connect fail,check the token", "error");
+            lost();
+        };
+    }
+
+    // lost fails the jobs in flight
+    function lost() {
+        jobOrder.forEach(function(id) {
+            var job = jobs[id];
+            if (job.status === "running") {
+                finish(job, null, { code: 0, message: "connection lost" });
+            }
+        });
+    }
+
+    // send calls method,internal calls are not listed in jobs
+    function send(method, params, internal, done) {
+        var id = nextId++;
+        var req = { jsonrpc: "2.0", method: method, id: id };
+        if (params !== undefined && params !== null) {
+            req.params = params;
+        }
+        var job = {
+            id: id,
+            method: method,
+            params: params,
+            status: "running",
+            started: new Date(),
+            progress: [],
+            internal: internal,
+            done: done
+        };
+        jobs[id] = job;
+        if (!internal) {
+            jobOrder.push(id);
+            while (jobOrder.length > maxJobs) {
+                delete jobs[jobOrder.shift()];
+            }
+        }
+        if (!ws || ws.readyState !== WebSocket.OPEN) {
+            finish(job, null, { code: 0, message: "not connected" });
+            return job;
+        }
+        ws.send(JSON.stringify(req) + "\n");
+        renderJobs();
+        return job;
+    }
+
+    function receive(line) {
+        var msg;
+        try {
+            msg = JSON.parse(line);
+        } catch (e) {
+            addEvent("invalid message", line);
+            return;
+        }
+        (Array.isArray(msg) ? msg : [msg]).forEach(function(m) {
+            if (m.method && m.id !== undefined && m.id !== null) {
+                request(m);
+                return;
+            }
+            if (m.method && m.result === undefined && m.error === undefined) {
+                notification(m);
+                return;
+            }
+            var job = jobs[m.id];
+            if (job) {
+                finish(job, m.result, m.error);
+            }
+        });
+    }
+
+    // request answers a call of the agent,rpc.confirm asks the user
+    function request(m) {
+        var reply = { jsonrpc: "2.0", id: m.id };
+        if (m.method === "rpc.confirm") {
+            var message = (m.params && m.params.message) || "confirm?";
+            reply.result = window.confirm(message);
+            addEvent("rpc.confirm", { message: message, confirmed: reply.result });
+        } else {
+            reply.error = { code: -32601, message: "console: can't find method \"" + m.method + "\"" };
+        }
+        if (ws && ws.readyState === WebSocket.OPEN) {
+            ws.send(JSON.stringify(reply) + "\n");
+        }
+    }
+
+    function notification(m) {
+        if (m.method === "rpc.event" && m.params && m.params.event) {
+            addEvent(m.params.event.topic, m.params.event.data);
+            return;
+        }
+        if (m.method === "rpc.progress" && m.params) {
+            var job = jobs[m.params.id];
+            if (job) {
+                job.progress.push({ at: new Date(), data: m.params.progress });
+                renderJobs();
+                return;
+            }
+        }
+        addEvent(m.method, m.params);
+    }
+
+    function finish(job, result, error) {
+        job.status = error ? "error" : "ok";
+        job.result = result;
+        job.error = error;
+        job.ended = new Date();
+        if (job.internal) {
+            delete jobs[job.id];
+        }
+        if (job.done) {
+            job.done(job);
+        }
+        renderJobs();
+    }
+
+    // services
+
+    var services = [];
+
+    function showServices(list) {
+        services = list;
+        renderServices();
+    }
+
+    function renderServices() {
+        var filter = $("filter").value.toLowerCase();
+        var ul = $("services");
+        ul.textContent = "";
+        services.forEach(function(s) {
+            var methods = (s.methods || []).filter(function(m) {
+                return (s.name + "." + m.name).toLowerCase().indexOf(filter) >= 0;
+            });
+            if (!methods.length) {
+                return;
+            }
+            var items = methods.map(function(m) {
+                var a = el("a", { href: "#", textContent: m.name });
+                a.onclick = function(e) {
+                    e.preventDefault();
+                    showMethod(s, m);
+                };
+                var li = el("li", {}, [a]);
+                if (m.names && m.names.length) {
+                    li.appendChild(el("span", { className: "names", textContent: " " + m.names.join(",") }));
+                }
+                return li;
+            });
+            ul.appendChild(el("li", { className: "service" }, [
+                el("span", { textContent: s.name + (s.proxy ? " (external)" : "") }),
+                el("ul", {}, items)
+            ]));
+        });
+    }
+
+    // params forms
+
+    // field returns the input of a value of schema,with a value() getter
+    // returning undefined for empty inputs
+    function field(schema) {
+        var type = schema ? schema.type : "";
+        var input;
+        switch (type) {
+            case "object":
+                if (schema.properties) {
+                    return objectField(schema);
+                }
+                return jsonField("{}");
+            case "string":
+                input = el("input", { type: "text" });
+                return {
+                    node: input,
+                    value: function() {
+                        return input.value === "" ? undefined : input.value;
+                    }
+                };
+            case "integer":
+            case "number":
+                input = el("input", { type: "number", step: type === "integer" ? "1" : "any" });
+                return {
+                    node: input,
+                    value: function() {
+                        return input.value === "" ? undefined : Number(input.value);
+                    }
+                };
+            case "boolean":
+                input = el("input", { type: "checkbox" });
+                return {
+                    node: input,
+                    value: function() {
+                        return input.checked;
+                    }
+                };
+            case "array":
+                return jsonField("[]");
+        }
+        return jsonField("null");
+    }
+
+    function objectField(schema) {
+        var fields = {};
+        var rows = Object.keys(schema.properties).sort().map(function(k) {
+            var p = schema.properties[k];
+            fields[k] = field(p);
+            return el("div", { className: "row" }, [
+                el("label", { textContent: k, title: p.goType || p.type || "any" }),
+                fields[k].node
+            ]);
+        });
+        return {
+            node: el("fieldset", {}, rows),
+            value: function() {
+                var v = {};
+                Object.keys(fields).forEach(function(k) {
+                    var fv = fields[k].value();
+                    if (fv !== undefined) {
+                        v[k] = fv;
+                    }
+                });
+                return v;
+            }
+        };
+    }
+
+    function jsonField(placeholder) {
+        var input = el("textarea", { rows: 3, placeholder: placeholder, className: "json" });
+        return {
+            node: input,
+            value: function() {
+                return input.value.trim() === "" ? undefined : JSON.parse(input.value);
+            }
+        };
+    }
+
+    function showMethod(service, method) {
+        var name = service.name + "." + method.name;
+        var section = $("method");
+        section.textContent = "";
+        section.appendChild(el("h2", { textContent: name }));
+        if (method.names && method.names.length) {
+            section.appendChild(el("p", { className: "hint", textContent: "also called as " + method.names.join(",") }));
+        }
+        if (method.result) {
+            section.appendChild(el("p", { className: "hint", textContent: "returns " + (method.result.goType || method.result.type || "any") }));
+        }
+        var form, raw = el("textarea", { rows: 6, className: "json", placeholder: "params json" });
+        if (service.proxy) {
+            // the params of external plugins are unknown
+            form = {
+                node: raw,
+                value: function() {
+                    return raw.value.trim() === "" ? undefined : JSON.parse(raw.value);
+                }
+            };
+        } else if (method.params) {
+            form = field(method.params);
+        }
+        if (form) {
+            section.appendChild(form.node);
+        } else {
+            section.appendChild(el("p", { className: "hint", textContent: "no params" }));
+        }
+        var error = el("span", { className: "error" });
+        var button = el("button", { textContent: "Call" });
+        button.onclick = function() {
+            error.textContent = "";
+            var params;
+            try {
+                params = form ? form.value() : undefined;
+            } catch (e) {
+                error.textContent = "params : " + e.message;
+                return;
+            }
+            selected = send(name, params, false).id;
+            showTab("jobs");
+            renderJobs();
+        };
+        section.appendChild(el("div", { className: "actions" }, [button, error]));
+    }
+
+    // jobs
+
+    // percent returns the percent of progress data like {done,total},
+    // or "" if it has none
+    function percent(data) {
+        if (data && typeof data === "object" && data.total > 0) {
+            return Math.floor(100 * data.done / data.total);
+        }
+        return "";
+    }
+
+    function duration(job) {
+        return (((job.ended || new Date()) - job.started) / 1000).toFixed(2) + "s";
+    }
+
+    function renderJobs() {
+        var tbody = $("job-list");
+        tbody.textContent = "";
+        jobOrder.slice().reverse().forEach(function(id) {
+            var job = jobs[id];
+            var last = job.progress.length ? job.progress[job.progress.length - 1].data : null;
+            var p = percent(last);
+            var bar = el("div", { className: "bar" }, [el("div", {})]);
+            bar.firstChild.style.width = (p === "" ? 0 : p) + "%";
+            var tr = el("tr", { className: id === selected ? "selected" : "" }, [
+                el("td", { textContent: id }),
+                el("td", { textContent: job.method }),
+                el("td", { className: job.status, textContent: job.status }),
+                el("td", {}, job.progress.length ? [bar, p === "" ? job.progress.length + " updates" : p + "%"] : []),
+                el("td", { textContent: clock(job.started) }),
+                el("td", { textContent: duration(job) })
+            ]);
+            tr.onclick = function() {
+                selected = id;
+                renderJobs();
+            };
+            tbody.appendChild(tr);
+        });
+        renderDetail();
+    }
+
+    function renderDetail() {
+        var d = $("job-detail");
+        d.textContent = "";
+        var job = jobs[selected];
+        if (!job) {
+            return;
+        }
+        d.appendChild(el("h3", { textContent: "#" + job.id + " " + job.method }));
+        d.appendChild(el("h4", { textContent: "params" }));
+        d.appendChild(el("pre", { textContent: job.params === undefined ? "none" : pretty(job.params) }));
+        if (job.progress.length) {
+            d.appendChild(el("h4", { textContent: "progress" }));
+            d.appendChild(el("pre", {
+                textContent: job.progress.map(function(p) {
+                    return clock(p.at) + " " + JSON.stringify(p.data);
+                }).join("\n")
+            }));
+        }
+        if (job.status === "running") {
+            return;
+        }
+        d.appendChild(el("h4", { textContent: job.error ? "error" : "result" }));
+        d.appendChild(el("pre", { className: job.error ? "error" : "", textContent: pretty(job.error || job.result) }));
+    }
+
+    // events
+
+    function addEvent(method, params) {
+        if ($("event-pause").checked) {
+            return;
+        }
+        events.push({ at: new Date(), method: method, params: params });
+        while (events.length > maxEvents) {
+            events.shift();
+        }
+        renderEvents();
+    }
+
+    function renderEvents() {
+        var filter = $("event-filter").value.toLowerCase();
+        var ul = $("event-list");
+        ul.textContent = "";
+        events.slice().reverse().forEach(function(e) {
+            var text = JSON.stringify(e.params);
+            if ((e.method + " " + text).toLowerCase().indexOf(filter) < 0) {
+                return;
+            }
+            ul.appendChild(el("li", {}, [
+                el("span", { className: "time", textContent: clock(e.at) + " " }),
+                el("b", { textContent: e.method + " " }),
+                el("code", { textContent: text === undefined ? "" : text })
+            ]));
+        });
+    }
+
+    function showTab(name) {
+        ["jobs", "events"].forEach(function(t) {
+            $(t).hidden = t !== name;
+            $("tab-" + t).className = t === name ? "active" : "";
+        });
+    }
+
+    $("token").value = sessionStorage.getItem("agentx.token") || "";
+    $("connect").onsubmit = function(e) {
+        e.preventDefault();
+        connect();
+    };
+    $("filter").oninput = renderServices;
+    $("event-filter").oninput = renderEvents;
+    $("event-clear").onclick = function() {
+        events = [];
+        renderEvents();
+    };
+    $("tab-jobs").onclick = function() {
+        showTab("jobs");
+    };
+    $("tab-events").onclick = function() {
+        showTab("events");
+    };
+    // keep the durations of running jobs moving
+    setInterval(function() {
+        for (var i = 0; i < jobOrder.length; i++) {
+            if (jobs[jobOrder[i]].status === "running") {
+                renderJobs();
+                return;
+            }
+        }
+    }, 1000);
+    connect();
+})();
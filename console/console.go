@@ -0,0 +1,26 @@
+// Package console is the web console of agentX,its assets are embedded in
+// the binary and served by the agent on rpc.console.
+//
+// the console connects to the agent over websocket with the token typed in,
+// lists the services from rpc.discover,generates the params forms from their
+// schemas,shows the progress of calls and lists the calls made and the
+// notifications received.
+package console
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed assets
+var assets embed.FS
+
+// Handler serves the console on the paths under prefix,like "/console/".
+func Handler(prefix string) http.Handler {
+	root, err := fs.Sub(assets, "assets")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix(prefix, http.FileServer(http.FS(root)))
+}
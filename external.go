@@ -0,0 +1,382 @@
+package main
+
+import (
+	"agentX/rpc"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	logger "github.com/snail007/mini-logger"
+)
+
+// external plugin protocol
+//
+// an external plugin is an executable which reads json-rpc 2.0 requests from
+// stdin and writes responses to stdout,one json document per line,anything
+// written to stderr is logged.
+// the first request after start is the handshake:
+//   -> {"jsonrpc":"2.0","method":"agentx.handshake","params":{"id":"<plugin id>","settings":{...}},"id":1}
+//   <- {"jsonrpc":"2.0","result":{"service":"echo","methods":["Say","Time"]},"id":1}
+// the following requests are calls of "<service>.<method>" with the params of the client.
+// when the settings change on reload the process is killed and restarted with them.
+
+const (
+	handshakeMethod = "agentx.handshake"
+	//maxExternalLine is the max size of a response line
+	maxExternalLine    = 64 * 1024 * 1024
+	maxRestartInterval = time.Second * 30
+)
+
+var (
+	errExternalExited = errors.New("external plugin exited")
+	errExternalClosed = errors.New("external plugin closed")
+)
+
+type handshakeParams struct {
+	ID       string                 `json:"id"`
+	Settings map[string]interface{} `json:"settings"`
+}
+type handshakeResult struct {
+	Service string   `json:"service"`
+	Methods []string `json:"methods"`
+}
+type externalRequest struct {
+	Version string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+	Id      uint64      `json:"id"`
+}
+type externalResponse struct {
+	Result *json.RawMessage `json:"result"`
+	Error  *rpc.RPCError    `json:"error"`
+	Id     uint64           `json:"id"`
+}
+
+//externalPlugin starts and supervises an external plugin process and
+//proxies calls of its service to it.
+type externalPlugin struct {
+	id     string
+	config PluginConfig
+	log    logger.MiniLogger
+
+	writeLock sync.Mutex
+	mutex     sync.Mutex
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	exited    chan bool
+	service   string
+	methods   map[string]bool
+	pending   map[uint64]chan *externalResponse
+	nextID    uint64
+	ready     bool
+	closed    bool
+	done      chan bool //closed by Close
+}
+
+func newExternalPlugin(id string, config PluginConfig, log logger.MiniLogger) *externalPlugin {
+	return &externalPlugin{
+		id:      id,
+		config:  config,
+		log:     log,
+		pending: map[uint64]chan *externalResponse{},
+		done:    make(chan bool),
+	}
+}
+
+//start starts the process and does the handshake
+func (p *externalPlugin) start() (err error) {
+	cmd := exec.Command(p.config.Command[0], p.config.Command[1:]...)
+	cmd.Dir = p.config.Dir
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return
+	}
+	if err = cmd.Start(); err != nil {
+		return
+	}
+	exited := make(chan bool)
+	p.mutex.Lock()
+	if p.closed {
+		//Close was called while starting,it did not see this process
+		p.mutex.Unlock()
+		cmd.Process.Kill()
+		cmd.Wait()
+		return errExternalClosed
+	}
+	p.cmd = cmd
+	p.stdin = stdin
+	p.exited = exited
+	p.ready = false
+	p.mutex.Unlock()
+	go p.logStderr(stderr)
+	go func() {
+		p.readResponses(stdout)
+		err := cmd.Wait()
+		p.mutex.Lock()
+		p.ready = false
+		pending := p.pending
+		p.pending = map[uint64]chan *externalResponse{}
+		p.mutex.Unlock()
+		for _, ch := range pending {
+			close(ch)
+		}
+		p.log.Warnf("external plugin exited : %v", err)
+		close(exited)
+	}()
+	var result handshakeResult
+	p.mutex.Lock()
+	settings := p.config.Settings
+	p.mutex.Unlock()
+	raw, err := p.request(handshakeMethod, handshakeParams{ID: p.id, Settings: settings})
+	if err == nil {
+		err = json.Unmarshal(*raw, &result)
+	}
+	if err != nil {
+		return fmt.Errorf("handshake fail : %s", err)
+	}
+	if result.Service == "" || len(result.Methods) == 0 {
+		return fmt.Errorf("handshake fail : no service name or methods reported")
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.service != "" && p.service != result.Service {
+		return fmt.Errorf("handshake fail : service changed from %q to %q", p.service, result.Service)
+	}
+	p.service = result.Service
+	p.methods = map[string]bool{}
+	for _, m := range result.Methods {
+		p.methods[m] = true
+	}
+	p.ready = true
+	return
+}
+
+//supervise restarts the process when it exits until Close is called,
+//the interval between restarts grows while starting fails.
+func (p *externalPlugin) supervise() {
+	interval := time.Second
+	for {
+		p.mutex.Lock()
+		exited := p.exited
+		p.mutex.Unlock()
+		<-exited
+		for {
+			select {
+			case <-p.done:
+				return
+			case <-time.After(interval):
+			}
+			err := p.start()
+			if err == errExternalClosed {
+				return
+			}
+			if err == nil {
+				p.log.Infof("external plugin restarted,pid %d", p.pid())
+				interval = time.Second
+				break
+			}
+			p.log.Errorf("restart external plugin fail : %s", err)
+			p.kill()
+			p.mutex.Lock()
+			exited = p.exited
+			p.mutex.Unlock()
+			<-exited
+			if interval *= 2; interval > maxRestartInterval {
+				interval = maxRestartInterval
+			}
+		}
+	}
+}
+
+func (p *externalPlugin) logStderr(stderr io.Reader) {
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		p.log.Info(scanner.Text())
+	}
+}
+
+func (p *externalPlugin) readResponses(stdout io.Reader) {
+	reader := bufio.NewReaderSize(stdout, 64*1024)
+	for {
+		line, err := rpc.ReadLine(reader, maxExternalLine)
+		if err != nil {
+			if err != io.EOF {
+				p.log.Errorf("read external plugin : %s", err)
+				p.kill()
+				io.Copy(ioutil.Discard, reader)
+			}
+			return
+		}
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		response := new(externalResponse)
+		if err = json.Unmarshal(line, response); err != nil {
+			p.log.Warnf("bad response from external plugin : %s", err)
+			continue
+		}
+		p.mutex.Lock()
+		ch := p.pending[response.Id]
+		delete(p.pending, response.Id)
+		p.mutex.Unlock()
+		if ch != nil {
+			ch <- response
+		}
+	}
+}
+
+//request sends a request and waits for its response within the timeout
+func (p *externalPlugin) request(method string, params interface{}) (result *json.RawMessage, err error) {
+	ch := make(chan *externalResponse, 1)
+	p.mutex.Lock()
+	p.nextID++
+	id := p.nextID
+	p.pending[id] = ch
+	stdin := p.stdin
+	p.mutex.Unlock()
+	b, err := json.Marshal(externalRequest{Version: "2.0", Method: method, Params: params, Id: id})
+	if err == nil {
+		p.writeLock.Lock()
+		_, err = stdin.Write(append(b, '\n'))
+		p.writeLock.Unlock()
+	}
+	if err != nil {
+		p.mutex.Lock()
+		delete(p.pending, id)
+		p.mutex.Unlock()
+		return
+	}
+	timer := time.NewTimer(p.config.Timeout)
+	defer timer.Stop()
+	select {
+	case response, ok := <-ch:
+		if !ok {
+			return nil, errExternalExited
+		}
+		if response.Error != nil {
+			return nil, response.Error
+		}
+		if response.Result == nil {
+			null := json.RawMessage("null")
+			response.Result = &null
+		}
+		return response.Result, nil
+	case <-timer.C:
+		p.mutex.Lock()
+		delete(p.pending, id)
+		p.mutex.Unlock()
+		return nil, fmt.Errorf("call %s timeout after %s", method, p.config.Timeout)
+	}
+}
+
+//Call implements rpc.Proxy
+func (p *externalPlugin) Call(method string, params *json.RawMessage) (result *json.RawMessage, err error) {
+	p.mutex.Lock()
+	ready := p.ready
+	service := p.service
+	p.mutex.Unlock()
+	if !ready {
+		return nil, fmt.Errorf("external plugin %s is not running", p.id)
+	}
+	if params == nil {
+		return p.request(service+"."+method, nil)
+	}
+	return p.request(service+"."+method, params)
+}
+
+//HasMethod implements rpc.Proxy
+func (p *externalPlugin) HasMethod(method string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.methods[method]
+}
+
+//Methods implements rpc.Proxy
+func (p *externalPlugin) Methods() (methods []string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for m := range p.methods {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	return
+}
+
+func (p *externalPlugin) pid() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.cmd == nil || p.cmd.Process == nil {
+		return 0
+	}
+	return p.cmd.Process.Pid
+}
+
+//Health implements HealthChecker
+func (p *externalPlugin) Health() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.ready {
+		return fmt.Errorf("external plugin is not running")
+	}
+	return nil
+}
+
+//Close implements Closer,it stops restarting,asks the process to exit by
+//closing stdin and SIGTERM,and kills it when ctx is done.
+func (p *externalPlugin) Close(ctx context.Context) error {
+	p.mutex.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.done)
+	}
+	cmd, stdin, exited := p.cmd, p.stdin, p.exited
+	p.mutex.Unlock()
+	if cmd == nil {
+		return nil
+	}
+	stdin.Close()
+	cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-exited:
+		return nil
+	case <-ctx.Done():
+		cmd.Process.Kill()
+		return ctx.Err()
+	}
+}
+
+//reconfigure kills the process to have it restarted with new settings
+func (p *externalPlugin) reconfigure(settings map[string]interface{}) {
+	p.mutex.Lock()
+	p.config.Settings = settings
+	p.mutex.Unlock()
+	p.kill()
+}
+
+//kill kills the process
+func (p *externalPlugin) kill() {
+	p.mutex.Lock()
+	cmd := p.cmd
+	p.mutex.Unlock()
+	if cmd != nil && cmd.Process != nil {
+		cmd.Process.Kill()
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	toml "github.com/pelletier/go-toml"
+	"github.com/spf13/cast"
+	"github.com/spf13/pflag"
+)
+
+//controller flags
+var (
+	controllerInventory   *string
+	controllerGroups      *[]string
+	controllerTags        *[]string
+	controllerHosts       *[]string
+	controllerParallel    *int
+	controllerMaxFailures *int
+	controllerTimeout     *int
+	controllerOutput      *string
+)
+
+func controllerFlags(fs *pflag.FlagSet) {
+	controllerInventory = fs.String("inventory", "inventory.toml", "inventory file of the agents")
+	controllerGroups = fs.StringSlice("group", nil, "select the agents of the groups")
+	controllerTags = fs.StringSlice("tag", nil, "select the agents having all the tags")
+	controllerHosts = fs.StringSlice("host", nil, "select the agents by name")
+	controllerParallel = fs.Int("parallel", 10, "max calls in flight")
+	controllerMaxFailures = fs.Int("max-failures", -1, "stop calling more agents after this many failures,-1 never stops")
+	controllerTimeout = fs.Int("timeout", 30, "seconds to wait for each agent")
+	controllerOutput = fs.String("output", "text", "text or json lines")
+}
+
+//inventory is the fleet of agents the controller calls,loaded from a file like:
+//  [agents.web-01]
+//  url = "http://10.0.0.11:9091/token"
+//  tags = ["web","prod"]
+//  [groups]
+//  frontend = ["web-01","web-02"]
+type inventory struct {
+	agents map[string]*fleetAgent
+	groups map[string][]string
+}
+
+type fleetAgent struct {
+	Name string   `json:"name"`
+	URL  string   `json:"url"`
+	Tags []string `json:"tags,omitempty"`
+}
+
+//fleetResult is the result of a call of one agent
+type fleetResult struct {
+	Host     string           `json:"host"`
+	OK       bool             `json:"ok"`
+	Skipped  bool             `json:"skipped,omitempty"`
+	Result   *json.RawMessage `json:"result,omitempty"`
+	Error    string           `json:"error,omitempty"`
+	Duration string           `json:"duration,omitempty"`
+}
+
+//fleetSummary is the aggregated result of a fan-out call
+type fleetSummary struct {
+	Method   string   `json:"method"`
+	Total    int      `json:"total"`
+	OK       int      `json:"ok"`
+	Failed   int      `json:"failed"`
+	Skipped  int      `json:"skipped"`
+	Failures []string `json:"failures,omitempty"`
+	Duration string   `json:"duration"`
+}
+
+func loadInventory(file string) (inv *inventory, err error) {
+	//not read by viper,it lowercases the agent names but not the group members
+	tree, err := toml.LoadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read inventory fail : %s", err)
+	}
+	doc := tree.ToMap()
+	inv = &inventory{agents: map[string]*fleetAgent{}, groups: map[string][]string{}}
+	sections := map[string]map[string]interface{}{}
+	for _, key := range []string{"agents", "groups"} {
+		if doc[key] == nil {
+			continue
+		}
+		if sections[key], err = cast.ToStringMapE(doc[key]); err != nil {
+			return nil, fmt.Errorf("inventory error : %s should be a section", key)
+		}
+	}
+	for name, section := range sections["agents"] {
+		m, err := cast.ToStringMapE(section)
+		if err != nil {
+			return nil, fmt.Errorf("inventory error : agents.%s should be a section", name)
+		}
+		a := &fleetAgent{Name: name, URL: cast.ToString(m["url"]), Tags: cast.ToStringSlice(m["tags"])}
+		if a.URL == "" {
+			return nil, fmt.Errorf("inventory error : agents.%s.url is not set", name)
+		}
+		inv.agents[name] = a
+	}
+	for group, members := range sections["groups"] {
+		names, err := cast.ToStringSliceE(members)
+		if err != nil {
+			return nil, fmt.Errorf("inventory error : groups.%s should be a list of agents", group)
+		}
+		for _, name := range names {
+			if inv.agents[name] == nil {
+				return nil, fmt.Errorf("inventory error : groups.%s : unknown agent %q", group, name)
+			}
+		}
+		inv.groups[group] = names
+	}
+	return
+}
+
+//selectAgents returns the agents of the hosts and groups having all the
+//tags sorted by name,all agents are candidates if no host or group is given.
+func (inv *inventory) selectAgents(hosts, groups, tags []string) (agents []*fleetAgent, err error) {
+	candidates := map[string]bool{}
+	for _, h := range hosts {
+		if inv.agents[h] == nil {
+			return nil, fmt.Errorf("unknown agent %q", h)
+		}
+		candidates[h] = true
+	}
+	for _, g := range groups {
+		members, ok := inv.groups[g]
+		if !ok {
+			return nil, fmt.Errorf("unknown group %q", g)
+		}
+		for _, name := range members {
+			candidates[name] = true
+		}
+	}
+	if len(hosts) == 0 && len(groups) == 0 {
+		for name := range inv.agents {
+			candidates[name] = true
+		}
+	}
+next:
+	for name := range candidates {
+		a := inv.agents[name]
+		for _, t := range tags {
+			if !hasString(a.Tags, t) {
+				continue next
+			}
+		}
+		agents = append(agents, a)
+	}
+	sort.Slice(agents, func(i, j int) bool { return agents[i].Name < agents[j].Name })
+	return
+}
+
+func hasString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+//controllerCommand is `agentx controller list|call`
+func controllerCommand(args []string) int {
+	usage := "usage: agentx controller list|call <method> [params json] [--inventory file] [--group g] [--tag t] [--host h] [--parallel n] [--max-failures n] [--timeout s] [--output text|json]"
+	if len(args) == 0 || (args[0] == "list" && len(args) != 1) || (args[0] == "call" && (len(args) < 2 || len(args) > 3)) ||
+		(args[0] != "list" && args[0] != "call") || (*controllerOutput != "text" && *controllerOutput != "json") || *controllerParallel <= 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+	inv, err := loadInventory(*controllerInventory)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	agents, err := inv.selectAgents(*controllerHosts, *controllerGroups, *controllerTags)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if args[0] == "list" {
+		for _, a := range agents {
+			if *controllerOutput == "json" {
+				b, _ := json.Marshal(a)
+				fmt.Println(string(b))
+			} else {
+				fmt.Printf("%s\t%s\t%s\n", a.Name, a.URL, strings.Join(a.Tags, ","))
+			}
+		}
+		return 0
+	}
+	var params *json.RawMessage
+	if len(args) == 3 {
+		raw := json.RawMessage(args[2])
+		if !json.Valid(raw) {
+			fmt.Fprintln(os.Stderr, "params should be json")
+			return 2
+		}
+		params = &raw
+	}
+	summary := fanOut(agents, args[1], params, func(r fleetResult) {
+		if *controllerOutput == "json" {
+			b, _ := json.Marshal(r)
+			fmt.Println(string(b))
+			return
+		}
+		switch {
+		case r.Skipped:
+			fmt.Printf("%s\tSKIPPED\n", r.Host)
+		case r.OK:
+			fmt.Printf("%s\tOK\t%s\t%s\n", r.Host, r.Duration, *r.Result)
+		default:
+			fmt.Printf("%s\tFAILED\t%s\t%s\n", r.Host, r.Duration, r.Error)
+		}
+	})
+	if *controllerOutput == "json" {
+		b, _ := json.Marshal(summary)
+		fmt.Println(string(b))
+	} else {
+		fmt.Printf("%s : %d agents,%d ok,%d failed,%d skipped in %s\n", summary.Method, summary.Total, summary.OK, summary.Failed, summary.Skipped, summary.Duration)
+	}
+	if summary.Failed > 0 || summary.Skipped > 0 {
+		return 1
+	}
+	return 0
+}
+
+//fanOut calls method of the agents with at most --parallel calls in flight,
+//each result is passed to report as soon as it is known. No more agents are
+//called once the failures reach --max-failures,they are reported skipped.
+func fanOut(agents []*fleetAgent, method string, params *json.RawMessage, report func(fleetResult)) (summary fleetSummary) {
+	started := time.Now()
+	summary.Method = method
+	summary.Total = len(agents)
+	client := &http.Client{Timeout: time.Duration(*controllerTimeout) * time.Second}
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan bool, *controllerParallel)
+	done := func(r fleetResult) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch {
+		case r.Skipped:
+			summary.Skipped++
+		case r.OK:
+			summary.OK++
+		default:
+			summary.Failed++
+			summary.Failures = append(summary.Failures, r.Host)
+		}
+		report(r)
+	}
+	for _, a := range agents {
+		slots <- true
+		mutex.Lock()
+		stop := *controllerMaxFailures >= 0 && summary.Failed >= *controllerMaxFailures
+		mutex.Unlock()
+		if stop {
+			<-slots
+			done(fleetResult{Host: a.Name, Skipped: true})
+			continue
+		}
+		wg.Add(1)
+		go func(a *fleetAgent) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			done(callAgent(client, a, method, params))
+		}(a)
+	}
+	wg.Wait()
+	sort.Strings(summary.Failures)
+	summary.Duration = time.Since(started).Round(time.Millisecond).String()
+	return
+}
+
+//callAgent posts the json-rpc call to the agent
+func callAgent(client *http.Client, a *fleetAgent, method string, params *json.RawMessage) (r fleetResult) {
+	r.Host = a.Name
+	started := time.Now()
+	defer func() {
+		r.Duration = time.Since(started).Round(time.Millisecond).String()
+	}()
+	body, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params, "id": 1})
+	resp, err := client.Post(a.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		r.Error = err.Error()
+		return
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		r.Error = err.Error()
+		return
+	}
+	var response struct {
+		Result *json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int         `json:"code"`
+			Message interface{} `json:"message"`
+		} `json:"error"`
+	}
+	if err = json.Unmarshal(data, &response); err != nil {
+		r.Error = fmt.Sprintf("bad response : %s", strings.TrimSpace(string(data)))
+		return
+	}
+	if response.Error != nil {
+		r.Error = fmt.Sprintf("%v (code %d)", response.Error.Message, response.Error.Code)
+		return
+	}
+	r.OK = true
+	r.Result = response.Result
+	if r.Result == nil {
+		null := json.RawMessage("null")
+		r.Result = &null
+	}
+	return
+}
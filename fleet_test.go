@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func TestLoadInventory(t *testing.T) {
+	tests := []struct {
+		name      string
+		inventory string
+		agents    []string //the agents of group g sorted
+		err       string   //a part of the error,empty if it loads
+	}{
+		{"mixed case", "[agents.Web-01]\nurl = \"http://a\"\n[agents.web-02]\nurl = \"http://b\"\n[groups]\ng = [\"Web-01\",\"web-02\"]\n", []string{"Web-01", "web-02"}, ""},
+		{"case sensitive", "[agents.Web-01]\nurl = \"http://a\"\n[groups]\ng = [\"web-01\"]\n", nil, `unknown agent "web-01"`},
+		{"no url", "[agents.a]\ntags = [\"x\"]\n", nil, "agents.a.url is not set"},
+		{"bad group", "[agents.a]\nurl = \"http://a\"\n[groups.g]\nx = 1\n", nil, "groups.g should be a list"},
+		{"bad toml", "[agents\n", nil, "read inventory fail"},
+	}
+	for _, test := range tests {
+		file := filepath.Join(t.TempDir(), "inventory.toml")
+		if err := ioutil.WriteFile(file, []byte(test.inventory), 0644); err != nil {
+			t.Fatal(err)
+		}
+		inv, err := loadInventory(file)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s : got error %v,want %q in it", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s : %s", test.name, err)
+			continue
+		}
+		agents, err := inv.selectAgents(nil, []string{"g"}, nil)
+		var names []string
+		for _, a := range agents {
+			names = append(names, a.Name)
+		}
+		if err != nil || strings.Join(names, ",") != strings.Join(test.agents, ",") {
+			t.Errorf("%s : got %q,%v,want %q", test.name, names, err, test.agents)
+		}
+	}
+}
+
+func TestFanOutMaxFailures(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","result":null,"error":{"code":-32603,"message":"x"},"id":1}`))
+	}))
+	defer ts.Close()
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	controllerFlags(fs)
+	*controllerParallel = 1
+	var agents []*fleetAgent
+	for _, name := range []string{"a", "b", "c", "d"} {
+		agents = append(agents, &fleetAgent{Name: name, URL: ts.URL})
+	}
+	tests := []struct {
+		maxFailures     int
+		failed, skipped int
+	}{
+		{-1, 4, 0},
+		{1, 1, 3},
+		{2, 2, 2},
+	}
+	for _, test := range tests {
+		*controllerMaxFailures = test.maxFailures
+		summary := fanOut(agents, "x.Y", nil, func(fleetResult) {})
+		if summary.Failed != test.failed || summary.Skipped != test.skipped {
+			t.Errorf("max failures %d : got %d failed %d skipped,want %d %d", test.maxFailures, summary.Failed, summary.Skipped, test.failed, test.skipped)
+		}
+	}
+}
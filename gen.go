@@ -0,0 +1,536 @@
+package main
+
+import (
+	"agentX/rpc"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+//gen flags
+var (
+	genLang    *string
+	genOut     *string
+	genPackage *string
+	genSrc     *string
+)
+
+func genFlags(fs *pflag.FlagSet) {
+	genLang = fs.String("lang", "go", "go or ts")
+	genOut = fs.String("out", "", "file to write,stdout if empty")
+	genPackage = fs.String("package", "agentx", "package name of the go stubs")
+	genSrc = fs.String("src", ".", "source directory of agentX,doc comments are read from it")
+}
+
+//genCommand is `agentx gen`,it registers the built-in plugins enabled by the
+//config like the agent does and writes typed client stubs of their services,
+//the types of params and results are generated from the go types with their
+//json tags and the doc comments found in their source.
+//external plugins are left out,their types are unknown.
+func genCommand(args []string) int {
+	if len(args) != 0 || (*genLang != "go" && *genLang != "ts") {
+		fmt.Fprintln(os.Stderr, "usage: agentx gen [--lang go|ts] [--out file] [--package name] [--src dir] [--config file]")
+		return 2
+	}
+	c, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	s, err := rpc.New()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for _, p := range plugins {
+		pc, err := c.plugin(p.id)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if !pc.Enabled {
+			continue
+		}
+		if err = s.Register(p.new(), pc.serviceName()); err != nil {
+			fmt.Fprintf(os.Stderr, "plugin %s : register service %q fail : %s\n", p.id, pc.serviceName(), err)
+			return 1
+		}
+	}
+	services := []rpc.ServiceInfo{}
+	for _, info := range s.Describe() {
+		if !info.Proxy {
+			services = append(services, info)
+		}
+	}
+	g := newGenerator(services)
+	var out []byte
+	if *genLang == "go" {
+		out, err = g.goStubs(*genPackage)
+	} else {
+		out = g.tsStubs()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *genOut == "" {
+		os.Stdout.Write(out)
+		return 0
+	}
+	if err = ioutil.WriteFile(*genOut, out, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+var (
+	typeOfTime       = reflect.TypeOf(time.Time{})
+	typeOfRawMessage = reflect.TypeOf(json.RawMessage{})
+)
+
+//generator generates the stubs of services
+type generator struct {
+	services []rpc.ServiceInfo
+	//names are the generated names of named struct types
+	names map[reflect.Type]string
+	//types are the named struct types in the order found
+	types   []reflect.Type
+	docs    map[string]*sourceDocs
+	imports map[string]bool
+}
+
+//sourceDocs are the doc comments of a package by "Type","Type.Field" and "Type.Method"
+type sourceDocs map[string]string
+
+func newGenerator(services []rpc.ServiceInfo) *generator {
+	g := &generator{
+		services: services,
+		names:    map[reflect.Type]string{},
+		docs:     map[string]*sourceDocs{},
+		imports:  map[string]bool{},
+	}
+	used := map[string]bool{}
+	for _, s := range services {
+		for _, m := range s.Methods {
+			for _, t := range []reflect.Type{m.ParamsType, m.ResultType} {
+				if t != nil {
+					g.collect(t, used)
+				}
+			}
+		}
+	}
+	return g
+}
+
+//collect names the named struct types reachable from t,a name used by a type
+//of another package is prefixed with the package name.
+func (g *generator) collect(t reflect.Type, used map[string]bool) {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		g.collect(t.Elem(), used)
+	case reflect.Map:
+		g.collect(t.Key(), used)
+		g.collect(t.Elem(), used)
+	case reflect.Struct:
+		if t == typeOfTime {
+			return
+		}
+		if t.Name() != "" {
+			if _, ok := g.names[t]; ok {
+				return
+			}
+			name := t.Name()
+			if used[name] {
+				pkg := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]
+				name = exportName(pkg) + name
+			}
+			used[name] = true
+			g.names[t] = name
+			g.types = append(g.types, t)
+		}
+		for _, f := range jsonFields(t) {
+			g.collect(f.Type, used)
+		}
+	}
+}
+
+//jsonField is a field as encoding/json sees it
+type jsonField struct {
+	reflect.StructField
+	//Owner is the struct type declaring the field
+	Owner     reflect.Type
+	JSONName  string
+	OmitEmpty bool
+}
+
+//jsonFields returns the fields of the struct t encoded by encoding/json,
+//fields of embedded structs without a json name are promoted.
+func jsonFields(t reflect.Type) (fields []jsonField) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if i := strings.Index(tag, ","); i >= 0 {
+			name, opts = tag[:i], tag[i:]
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			fields = append(fields, jsonFields(ft)...)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, jsonField{StructField: f, Owner: t, JSONName: name, OmitEmpty: strings.Contains(opts, ",omitempty")})
+	}
+	return
+}
+
+//doc returns the doc comment of key in the source of the package of t,
+//empty if the source can not be found.
+func (g *generator) doc(t reflect.Type, key string) string {
+	if t == nil || t.PkgPath() == "" {
+		return ""
+	}
+	docs, ok := g.docs[t.PkgPath()]
+	if !ok {
+		docs = parseDocs(t.PkgPath())
+		g.docs[t.PkgPath()] = docs
+	}
+	if docs == nil {
+		return ""
+	}
+	return (*docs)[key]
+}
+
+//parseDocs parses the doc comments of the go files of the package path,
+//the packages of agentX are read from --src,others from GOPATH
+func parseDocs(path string) *sourceDocs {
+	var dir string
+	if path == "main" {
+		dir = *genSrc
+	} else if strings.HasPrefix(path, "agentX/") {
+		dir = filepath.Join(*genSrc, strings.TrimPrefix(path, "agentX/"))
+	}
+	if _, err := os.Stat(dir); dir == "" || (err != nil && path != "main") {
+		if pkg, err := build.Import(path, "", build.FindOnly); err == nil {
+			dir = pkg.Dir
+		}
+	}
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, parser.ParseComments)
+	if err == nil && path == "main" && pkgs["main"] == nil {
+		err = fmt.Errorf("no package main")
+	}
+	if err != nil || len(pkgs) == 0 {
+		fmt.Fprintf(os.Stderr, "source of package %s not found in %q,its doc comments are left out,see --src\n", path, dir)
+		return nil
+	}
+	docs := sourceDocs{}
+	text := func(groups ...*ast.CommentGroup) string {
+		for _, c := range groups {
+			if c != nil {
+				return strings.TrimSpace(c.Text())
+			}
+		}
+		return ""
+	}
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				switch d := decl.(type) {
+				case *ast.GenDecl:
+					for _, spec := range d.Specs {
+						ts, ok := spec.(*ast.TypeSpec)
+						if !ok {
+							continue
+						}
+						groups := []*ast.CommentGroup{ts.Doc}
+						if len(d.Specs) == 1 {
+							groups = append(groups, d.Doc)
+						}
+						docs[ts.Name.Name] = text(groups...)
+						st, ok := ts.Type.(*ast.StructType)
+						if !ok {
+							continue
+						}
+						for _, f := range st.Fields.List {
+							for _, n := range f.Names {
+								docs[ts.Name.Name+"."+n.Name] = text(f.Doc, f.Comment)
+							}
+						}
+					}
+				case *ast.FuncDecl:
+					if d.Recv == nil || len(d.Recv.List) == 0 {
+						continue
+					}
+					rt := d.Recv.List[0].Type
+					if star, ok := rt.(*ast.StarExpr); ok {
+						rt = star.X
+					}
+					if id, ok := rt.(*ast.Ident); ok {
+						docs[id.Name+"."+d.Name.Name] = text(d.Doc)
+					}
+				}
+			}
+		}
+	}
+	return &docs
+}
+
+//exportName returns s as an exported go identifier,"scm.git.v2" is "ScmGitV2"
+func exportName(s string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range s {
+		if r == '.' || r == '_' || r == '-' {
+			upper = true
+			continue
+		}
+		if upper {
+			b.WriteString(strings.ToUpper(string(r)))
+			upper = false
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+//comment returns text as comment lines with prefix
+func comment(text, prefix string) string {
+	var b strings.Builder
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		b.WriteString(strings.TrimRight(prefix+line, " ") + "\n")
+	}
+	return b.String()
+}
+
+//methodDoc returns the doc of method m of service s
+func (g *generator) methodDoc(s rpc.ServiceInfo, m rpc.MethodInfo) string {
+	doc := ""
+	if s.Type != nil {
+		t := s.Type
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		doc = g.doc(t, t.Name()+"."+m.Name)
+	}
+	if doc == "" {
+		doc = fmt.Sprintf("%s calls %s.%s.", m.Name, s.Name, m.Name)
+	}
+	return doc
+}
+
+//typeDoc returns the doc of the generated type of t
+func (g *generator) typeDoc(t reflect.Type) string {
+	doc := g.doc(t, t.Name())
+	if doc == "" {
+		doc = fmt.Sprintf("%s is generated from %s.", g.names[t], t.String())
+	}
+	return doc
+}
+
+//goType returns the go type of t in the stubs
+func (g *generator) goType(t reflect.Type) string {
+	switch t {
+	case typeOfTime:
+		g.imports["time"] = true
+		return "time.Time"
+	case typeOfRawMessage:
+		g.imports["encoding/json"] = true
+		return "json.RawMessage"
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return "*" + g.goType(t.Elem())
+	case reflect.Slice:
+		return "[]" + g.goType(t.Elem())
+	case reflect.Array:
+		return fmt.Sprintf("[%d]%s", t.Len(), g.goType(t.Elem()))
+	case reflect.Map:
+		return "map[" + g.goType(t.Key()) + "]" + g.goType(t.Elem())
+	case reflect.Interface:
+		return "interface{}"
+	case reflect.Struct:
+		if name, ok := g.names[t]; ok {
+			return name
+		}
+		var b bytes.Buffer
+		b.WriteString("struct {\n")
+		g.goFields(&b, t)
+		b.WriteString("}")
+		return b.String()
+	}
+	//named basic types are generated as their underlying type
+	return t.Kind().String()
+}
+
+func (g *generator) goFields(b *bytes.Buffer, t reflect.Type) {
+	for _, f := range jsonFields(t) {
+		if doc := g.doc(f.Owner, f.Owner.Name()+"."+f.Name); doc != "" && f.Owner.Name() != "" {
+			b.WriteString(comment(doc, "\t// "))
+		}
+		tag := f.Tag.Get("json")
+		if tag == "" {
+			tag = f.JSONName
+		}
+		fmt.Fprintf(b, "\t%s %s `json:%q`\n", f.Name, g.goType(f.Type), tag)
+	}
+}
+
+//goStubs generates the go stubs built on the client package
+func (g *generator) goStubs(pkg string) ([]byte, error) {
+	body := new(bytes.Buffer)
+	for _, t := range g.types {
+		body.WriteString("\n" + comment(g.typeDoc(t), "// "))
+		fmt.Fprintf(body, "type %s struct {\n", g.names[t])
+		g.goFields(body, t)
+		body.WriteString("}\n")
+	}
+	for _, s := range g.services {
+		name := exportName(s.Name) + "Service"
+		fmt.Fprintf(body, "\n// %s is the client of the %s service.\ntype %s struct {\n\tc    *client.Client\n\tname string\n}\n", name, s.Name, name)
+		fmt.Fprintf(body, "\n// New%s returns the client of the %s service of c.\nfunc New%s(c *client.Client) *%s {\n\treturn &%s{c: c, name: %q}\n}\n", name, s.Name, name, name, name, s.Name)
+		for _, m := range s.Methods {
+			body.WriteString("\n" + comment(g.methodDoc(s, m), "// "))
+			params, args := "", "nil"
+			if m.ParamsType != nil {
+				params, args = ", params "+g.goType(m.ParamsType), "params"
+			}
+			fmt.Fprintf(body, "func (s *%s) %s(ctx context.Context%s) (result %s, err error) {\n", name, m.Name, params, g.goType(m.ResultType))
+			fmt.Fprintf(body, "\terr = s.c.Call(ctx, s.name+%q, %s, &result)\n\treturn\n}\n", "."+m.Name, args)
+		}
+	}
+	g.imports["agentX/client"] = true
+	g.imports["context"] = true
+	imports := []string{}
+	for path := range g.imports {
+		imports = append(imports, fmt.Sprintf("\t%q\n", path))
+	}
+	sort.Strings(imports)
+	head := fmt.Sprintf("// Code generated by agentx gen. DO NOT EDIT.\n\n// Package %s is the client of the agentX services.\npackage %s\n\nimport (\n%s)\n", pkg, pkg, strings.Join(imports, ""))
+	return format.Source([]byte(head + body.String()))
+}
+
+//tsType returns the typescript type of t in the stubs
+func (g *generator) tsType(t reflect.Type) string {
+	switch t {
+	case typeOfTime:
+		return "string"
+	case typeOfRawMessage:
+		return "any"
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.tsType(t.Elem())
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			//[]byte is base64 in json
+			return "string"
+		}
+		elem := g.tsType(t.Elem())
+		if strings.ContainsAny(elem, " |") {
+			elem = "(" + elem + ")"
+		}
+		return elem + "[]"
+	case reflect.Map:
+		return "{ [key: string]: " + g.tsType(t.Elem()) + " }"
+	case reflect.Interface:
+		return "any"
+	case reflect.Struct:
+		if name, ok := g.names[t]; ok {
+			return name
+		}
+		var b bytes.Buffer
+		b.WriteString("{\n")
+		g.tsFields(&b, t, "\t\t")
+		b.WriteString("\t}")
+		return b.String()
+	case reflect.Bool:
+		return "boolean"
+	case reflect.String:
+		return "string"
+	}
+	return "number"
+}
+
+func (g *generator) tsFields(b *bytes.Buffer, t reflect.Type, indent string) {
+	for _, f := range jsonFields(t) {
+		if doc := g.doc(f.Owner, f.Owner.Name()+"."+f.Name); doc != "" && f.Owner.Name() != "" {
+			b.WriteString(indent + "/**\n" + comment(doc, indent+" * ") + indent + " */\n")
+		}
+		optional := ""
+		if f.OmitEmpty || f.Type.Kind() == reflect.Ptr {
+			optional = "?"
+		}
+		fmt.Fprintf(b, "%s%s%s: %s;\n", indent, tsKey(f.JSONName), optional, g.tsType(f.Type))
+	}
+}
+
+//tsKey quotes json names which are not identifiers
+func tsKey(name string) string {
+	for i, r := range name {
+		if !(r == '_' || r == '$' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') {
+			return fmt.Sprintf("%q", name)
+		}
+	}
+	return name
+}
+
+//tsStubs generates typescript stubs calling through a Call function,
+//like one posting json-rpc to the agent
+func (g *generator) tsStubs() []byte {
+	b := new(bytes.Buffer)
+	b.WriteString("// Code generated by agentx gen. DO NOT EDIT.\n\n")
+	b.WriteString("/**\n * Call sends a json-rpc call of method to the agent and resolves to its result.\n */\n")
+	b.WriteString("export type Call = (method: string, params?: unknown) => Promise<unknown>;\n")
+	for _, t := range g.types {
+		b.WriteString("\n/**\n" + comment(g.typeDoc(t), " * ") + " */\n")
+		fmt.Fprintf(b, "export interface %s {\n", g.names[t])
+		g.tsFields(b, t, "\t")
+		b.WriteString("}\n")
+	}
+	for _, s := range g.services {
+		name := exportName(s.Name) + "Service"
+		fmt.Fprintf(b, "\n/**\n * %s is the client of the %s service.\n */\n", name, s.Name)
+		fmt.Fprintf(b, "export class %s {\n\tconstructor(private call: Call, private name = %q) {}\n", name, s.Name)
+		for _, m := range s.Methods {
+			b.WriteString("\n\t/**\n" + comment(g.methodDoc(s, m), "\t * ") + "\t */\n")
+			params, args := "", ""
+			if m.ParamsType != nil {
+				params, args = "params: "+g.tsType(m.ParamsType), ", params"
+			}
+			fmt.Fprintf(b, "\t%s(%s): Promise<%s> {\n", strings.ToLower(m.Name[:1])+m.Name[1:], params, g.tsType(m.ResultType))
+			fmt.Fprintf(b, "\t\treturn this.call(this.name + %q%s) as Promise<%s>;\n\t}\n", "."+m.Name, args, g.tsType(m.ResultType))
+		}
+		b.WriteString("}\n")
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+//lineReader reads lines of a terminal with history and tab completion,
+//it reads plain lines when in is not a terminal.
+type lineReader struct {
+	in       *os.File
+	out      io.Writer
+	reader   *bufio.Reader
+	complete func(line string) []string
+	history  []string
+	terminal bool
+}
+
+func newLineReader(in *os.File, out io.Writer, complete func(line string) []string) *lineReader {
+	r := &lineReader{in: in, out: out, reader: bufio.NewReader(in), complete: complete}
+	if restore, err := makeRaw(int(in.Fd())); err == nil {
+		restore()
+		r.terminal = true
+	}
+	return r
+}
+
+//ReadLine reads a line after printing prompt,io.EOF at the end of input or ctrl-d
+func (r *lineReader) ReadLine(prompt string) (line string, err error) {
+	fmt.Fprint(r.out, prompt)
+	if !r.terminal {
+		line, err = r.reader.ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		r.addHistory(strings.TrimSpace(line))
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	restore, err := makeRaw(int(r.in.Fd()))
+	if err != nil {
+		return
+	}
+	defer restore()
+	buf := []rune{}
+	pos := len(r.history)
+	redraw := func() {
+		fmt.Fprintf(r.out, "\r\033[K%s%s", prompt, string(buf))
+	}
+	for {
+		c, _, err := r.reader.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch c {
+		case '\r', '\n':
+			fmt.Fprint(r.out, "\n")
+			line = string(buf)
+			r.addHistory(strings.TrimSpace(line))
+			return line, nil
+		case 3: //ctrl-c drops the line
+			fmt.Fprint(r.out, "^C\n")
+			buf = buf[:0]
+			pos = len(r.history)
+			redraw()
+		case 4: //ctrl-d
+			if len(buf) == 0 {
+				fmt.Fprint(r.out, "\n")
+				return "", io.EOF
+			}
+		case 21: //ctrl-u
+			buf = buf[:0]
+			redraw()
+		case 127, 8:
+			if len(buf) > 0 {
+				buf = buf[:len(buf)-1]
+				redraw()
+			}
+		case '\t':
+			buf = []rune(r.completeLine(string(buf), prompt))
+			redraw()
+		case 27:
+			//arrows are ESC [ A-D,up and down walk the history
+			if b, _, _ := r.reader.ReadRune(); b != '[' {
+				continue
+			}
+			b, _, _ := r.reader.ReadRune()
+			switch {
+			case b == 'A' && pos > 0:
+				pos--
+				buf = []rune(r.history[pos])
+			case b == 'B' && pos < len(r.history):
+				pos++
+				buf = buf[:0]
+				if pos < len(r.history) {
+					buf = []rune(r.history[pos])
+				}
+			}
+			redraw()
+		default:
+			if c >= 32 {
+				buf = append(buf, c)
+				fmt.Fprint(r.out, string(c))
+			}
+		}
+	}
+}
+
+//completeLine completes line to the common prefix of the completions,
+//they are listed when there are several.
+func (r *lineReader) completeLine(line, prompt string) string {
+	completions := r.complete(line)
+	if len(completions) == 0 {
+		return line
+	}
+	prefix := completions[0]
+	for _, c := range completions[1:] {
+		for !strings.HasPrefix(c, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if len(completions) > 1 && prefix == line {
+		fmt.Fprintf(r.out, "\n%s\n", strings.Join(completions, "  "))
+	}
+	if len(prefix) > len(line) {
+		return prefix
+	}
+	return line
+}
+
+func (r *lineReader) addHistory(line string) {
+	if line == "" || (len(r.history) > 0 && r.history[len(r.history)-1] == line) {
+		return
+	}
+	r.history = append(r.history, line)
+}
+
+//WaitKey waits for a key on a terminal or a line otherwise
+func (r *lineReader) WaitKey() {
+	if !r.terminal {
+		r.reader.ReadString('\n')
+		return
+	}
+	if restore, err := makeRaw(int(r.in.Fd())); err == nil {
+		defer restore()
+	}
+	r.reader.ReadRune()
+}
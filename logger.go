@@ -2,6 +2,8 @@ package main
 
 import (
 	"agentX/utils"
+	"sync/atomic"
+	"time"
 
 	"github.com/snail007/mini-logger"
 	"github.com/snail007/mini-logger/writers/console"
@@ -11,41 +13,99 @@ import (
 var log logger.MiniLogger
 var accessLog logger.MiniLogger
 
+//consoleLevels maps log.console-level to the levels shown in console
+var consoleLevels = map[string]uint8{
+	"debug": logger.AllLevels,
+	"info":  logger.InfoLevel | logger.WarnLevel | logger.ErrorLevel | logger.FatalLevel,
+	"warn":  logger.WarnLevel | logger.ErrorLevel | logger.FatalLevel,
+	"error": logger.ErrorLevel | logger.FatalLevel,
+	"fatal": logger.FatalLevel,
+}
+
+//fileLevels maps log.level to the levels written to the file of that name
+var fileLevels = map[string]uint8{
+	"debug": logger.AllLevels,
+	"info":  logger.InfoLevel,
+	"error": logger.WarnLevel | logger.ErrorLevel | logger.FatalLevel,
+}
+
+//flushMarker is logged by flushLog,it is never written
+const flushMarker = "\x00flush"
+
+//logWriters are all writers added to log
+var logWriters []*levelWriter
+
+//levelWriter drops entries not in levels,levels can be changed at runtime
+type levelWriter struct {
+	logger.Writer
+	levels  uint32
+	flushed chan bool
+}
+
+func newLevelWriter(w logger.Writer, levels uint8) *levelWriter {
+	lw := &levelWriter{Writer: w, flushed: make(chan bool, 1)}
+	lw.setLevels(levels)
+	logWriters = append(logWriters, lw)
+	return lw
+}
+func (w *levelWriter) Write(e logger.Entry) {
+	if e.Content == flushMarker {
+		w.flushed <- true
+		return
+	}
+	if uint8(atomic.LoadUint32(&w.levels))&e.Level == e.Level {
+		w.Writer.Write(e)
+	}
+}
+func (w *levelWriter) setLevels(levels uint8) {
+	atomic.StoreUint32(&w.levels, uint32(levels))
+}
+
+//flushLog waits until the entries logged before are written,
+//mini-logger writes asynchronously and its safe mode is not reliable.
+func flushLog() {
+	log.Debug(flushMarker)
+	for _, w := range logWriters {
+		select {
+		case <-w.flushed:
+		case <-time.After(time.Second):
+		}
+	}
+}
+
 //initLog
 func initLog() {
-	var level uint8
-	switch cfg.GetString("log.console-level") {
-	case "debug":
-		level = logger.AllLevels
-	case "info":
-		level = logger.InfoLevel | logger.WarnLevel | logger.ErrorLevel | logger.FatalLevel
-	case "warn":
-		level = logger.WarnLevel | logger.ErrorLevel | logger.FatalLevel
-	case "error":
-		level = logger.ErrorLevel | logger.FatalLevel
-	case "fatal":
-		level = logger.FatalLevel
-	default:
-		level = 0
-	}
+	cfg := conf()
 	log = logger.New(false, nil)
-	log.AddWriter(console.New(console.ConsoleWriterConfig{
+	consoleWriter := newLevelWriter(console.New(console.ConsoleWriterConfig{
 		Format: "{date} {time}.{mili} {level} {fields} {text}",
 		Type:   console.T_TEXT,
-	}), level)
-	cfgF := files.GetDefaultFileConfig()
-	cfgF.LogPath = cfg.GetString("log.dir")
-	cfgF.MaxBytes = cfg.GetInt64("log.FileMaxSize")
-	cfgF.MaxCount = cfg.GetInt("log.MaxCount")
-	cfgLevels := cfg.GetStringSlice("log.level")
-	if ok, _ := utils.InArray("debug", cfgLevels); ok {
-		cfgF.FileNameSet["debug"] = logger.AllLevels
-	}
-	if ok, _ := utils.InArray("info", cfgLevels); ok {
-		cfgF.FileNameSet["info"] = logger.InfoLevel
+	}), consoleLevels[cfg.Log.ConsoleLevel])
+	log.AddWriter(consoleWriter, logger.AllLevels)
+	//a file writer for each level file,whose levels change on reload
+	fileWriters := map[string]*levelWriter{}
+	for _, name := range []string{"debug", "info", "error"} {
+		cfgF := files.GetDefaultFileConfig()
+		cfgF.LogPath = cfg.Log.Dir
+		cfgF.MaxBytes = cfg.Log.FileMaxSize
+		cfgF.MaxCount = cfg.Log.MaxCount
+		cfgF.FileNameSet = map[string]uint8{name: fileLevels[name]}
+		fileWriters[name] = newLevelWriter(files.New(cfgF), 0)
+		log.AddWriter(fileWriters[name], logger.AllLevels)
 	}
-	if ok, _ := utils.InArray("error", cfgLevels); ok {
-		cfgF.FileNameSet["error"] = logger.WarnLevel | logger.ErrorLevel | logger.FatalLevel
+	setFileLevels := func(levels []string) {
+		for name, w := range fileWriters {
+			if ok, _ := utils.InArray(name, levels); ok {
+				w.setLevels(fileLevels[name])
+			} else {
+				w.setLevels(0)
+			}
+		}
 	}
-	log.AddWriter(files.New(cfgF), logger.AllLevels)
+	setFileLevels(cfg.Log.Level)
+	onReload(func(old, new *Config) error {
+		consoleWriter.setLevels(consoleLevels[new.Log.ConsoleLevel])
+		setFileLevels(new.Log.Level)
+		return nil
+	})
 }
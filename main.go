@@ -1,51 +1,158 @@
 package main
 
 import (
-	"agentX/plugins/gitx"
-	"agentX/plugins/systemx"
-	"net/http"
+	"agentX/console"
+	"agentX/rpc"
+	"context"
+	"crypto/tls"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"fmt"
 
-	"github.com/julienschmidt/httprouter"
+	"github.com/spf13/pflag"
 
 	"os"
 )
 
-func main() {
+var (
+	srv *rpc.Server
+	//reverse is the link to the controller,nil if not configured
+	reverse *reverseClient
+)
 
+func main() {
+	parseFlags()
+	if pflag.NArg() > 0 {
+		os.Exit(runCommand(pflag.Args()))
+	}
 	fmt.Println(poster())
 	err := initConfig()
 	if err != nil {
-		fmt.Printf("%s", err)
-		os.Exit(0)
+		fmt.Println(err)
+		os.Exit(1)
 	}
 
 	initLog()
 
-	registRpcService()
+	if err = watchConfig(); err != nil {
+		log.Warnf("watch config file fail,hot reload disabled : %s", err)
+	}
+
+	if err = initRpcServer(); err != nil {
+		log.Error(err)
+		flushLog()
+		os.Exit(1)
+	}
+
+	if err = registRpcService(); err != nil {
+		log.Error(err)
+		shutdown()
+		os.Exit(1)
+	}
+
+	srv.Start()
 
-	initRpcWeb()
+	if c := conf().Reverse; c.URL != "" {
+		if reverse, err = newReverseClient(c); err != nil {
+			log.Error(err)
+			shutdown()
+			os.Exit(1)
+		}
+		go reverse.run()
+	}
+
+	if c := conf().Register; c.URL != "" {
+		if register, err = newRegistration(c); err != nil {
+			log.Error(err)
+			shutdown()
+			os.Exit(1)
+		}
+		go register.run()
+	}
 
 	log.Info("agentX service stared")
 
-	go func() {
-		http.ListenAndServe(":25900", http.StripPrefix("/", http.FileServer(http.Dir("./clients/js"))))
-	}()
-	select {}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	log.Infof("received %s,shutting down", <-signals)
+	shutdown()
 }
-func registRpcService() {
-	//注册plugins下面的rpc服务
-	services.register(new(gitx.Gitx), "git")
-	services.register(new(systemx.SystemX), "system")
+
+//shutdown closes the services within agentX.shutdownTimeout
+func shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(conf().AgentX.ShutdownTimeout)*time.Second)
+	defer cancel()
+	if reverse != nil {
+		reverse.Close()
+	}
+	if register != nil {
+		register.Close()
+	}
+	srv.Close(ctx)
+	log.Info("agentX service stopped")
+	flushLog()
 }
 
-//init rpc web service
-func initRpcWeb() {
-	router := httprouter.New()
-	router.Handle("GET", "/:token", serve)
-	router.Handle("POST", "/:token", serve)
-	router.Handle("OPTIONS", "/:token", serve)
-	go http.ListenAndServe(cfg.GetString("rpc.listen"), router)
+//initRpcServer builds the rpc server from config
+func initRpcServer() (err error) {
+	mapping, err := rpc.ParseNameMapping(conf().RPC.MethodNames)
+	if err != nil {
+		return
+	}
+	opts := []rpc.Option{
+		rpc.WithListen(conf().RPC.Listen),
+		rpc.WithLogger(log),
+		rpc.WithNameMapping(mapping),
+		rpc.WithAuth(authorize),
+	}
+	if u := conf().RPC.Unix; u.Path != "" {
+		mode, _ := u.fileMode()
+		gid, _ := u.gid()
+		opts = append(opts, rpc.WithUnixListen(u.Path, mode, gid))
+	}
+	if t := conf().RPC.TCP; t.Listen != "" {
+		var config *tls.Config
+		if config, err = t.tlsConfig(); err != nil {
+			return
+		}
+		opts = append(opts, rpc.WithTCPListen(t.Listen, config))
+	}
+	limits := rpcLimits(conf())
+	opts = append(opts, rpc.WithReadLimit(limits.ReadLimit))
+	if c := conf().RPC.Compression; c.HTTP || c.WebSocket {
+		opts = append(opts, rpc.WithCompression(rpc.Compression{HTTP: c.HTTP, WebSocket: c.WebSocket, Threshold: c.Threshold, Level: c.Level}))
+	}
+	opts = append(opts, rpc.WithKeepalive(limits.Ping, limits.ReadTimeout, limits.WriteTimeout))
+	opts = append(opts, rpc.WithSessions(limits.SessionTTL, limits.SessionBuffer))
+	if conf().RPC.REST {
+		opts = append(opts, rpc.WithREST())
+	}
+	if path := conf().RPC.Console; path != "" {
+		opts = append(opts, rpc.WithHandler(path, console.Handler(path)))
+	}
+	for name, version := range conf().RPC.DefaultVersion {
+		opts = append(opts, rpc.WithDefaultVersion(name, version.(string)))
+	}
+	if srv, err = rpc.New(opts...); err != nil {
+		return
+	}
+	onReload(func(old, new *Config) error {
+		return srv.SetLimits(rpcLimits(new))
+	})
+	return
+}
 
+//rpcLimits are the limits of the rpc server in c,they apply on reload
+func rpcLimits(c *Config) rpc.Limits {
+	ws := c.RPC.WebSocket
+	return rpc.Limits{
+		ReadLimit:     c.RPC.ReadLimit,
+		Ping:          time.Duration(ws.Ping) * time.Second,
+		ReadTimeout:   time.Duration(ws.ReadTimeout) * time.Second,
+		WriteTimeout:  time.Duration(ws.WriteTimeout) * time.Second,
+		SessionTTL:    time.Duration(ws.SessionTTL) * time.Second,
+		SessionBuffer: ws.SessionBuffer,
+	}
 }
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"agentX/plugins/gitx"
+	"agentX/plugins/systemx"
+	"agentX/rpc"
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	logger "github.com/snail007/mini-logger"
+	"github.com/spf13/cast"
+)
+
+//plugin is a built-in rpc service,it is enabled and named by the
+//[plugins.<id>] config section,the other keys in the section are its settings
+//which are passed to Init if the service is an Initializer,again when they
+//change on reload.
+type plugin struct {
+	id  string
+	new func() interface{}
+}
+
+var plugins = []plugin{
+	{id: "git", new: func() interface{} { return new(gitx.Gitx) }},
+	{id: "system", new: func() interface{} { return new(systemx.SystemX) }},
+	{id: "admin", new: func() interface{} { return new(Admin) }},
+}
+
+//PluginConfig is the [plugins.<id>] config section.
+//a section with a command is an external plugin,see externalPlugin,
+//its command,dir and timeout keys are not settings.
+type PluginConfig struct {
+	Enabled bool
+	Name    string
+	//Version registers the service as "name.version" when set
+	Version  string
+	Settings map[string]interface{}
+	//Aliases is [plugins.<id>.aliases],alias = "Method" makes calls of
+	//"name.alias" go to "name.Method"
+	Aliases map[string]string
+	Command []string
+	Dir     string
+	Timeout time.Duration
+}
+
+//plugin returns the config of plugin id,built-in plugins are enabled
+//and named by their id when not configured,external plugins are named
+//by the service they report when name is not set.
+func (c *Config) plugin(id string) (p PluginConfig, err error) {
+	p = PluginConfig{Enabled: true, Settings: map[string]interface{}{}, Timeout: time.Second * 30}
+	if c.Plugins[id] == nil {
+		p.Name = id
+		return
+	}
+	section, err := cast.ToStringMapE(c.Plugins[id])
+	if err != nil {
+		err = fmt.Errorf("plugins.%s : should be a section", id)
+		return
+	}
+	_, external := section["command"]
+	for k, v := range section {
+		switch {
+		case k == "enabled":
+			if p.Enabled, err = cast.ToBoolE(v); err != nil {
+				err = fmt.Errorf("plugins.%s.enabled : expected bool,got %#v", id, v)
+				return
+			}
+		case k == "name":
+			if p.Name, err = cast.ToStringE(v); err != nil || !isServiceName(p.Name) {
+				err = fmt.Errorf("plugins.%s.name : expected dotted service name like git or git.repo,got %#v", id, v)
+				return
+			}
+		case k == "version":
+			if p.Version, err = cast.ToStringE(v); err != nil || !rpc.IsVersion(p.Version) {
+				err = fmt.Errorf("plugins.%s.version : expected version like v1,got %#v", id, v)
+				return
+			}
+		case k == "aliases":
+			if p.Aliases, err = cast.ToStringMapStringE(v); err != nil {
+				err = fmt.Errorf("plugins.%s.aliases : should be a section of alias = \"Method\"", id)
+				return
+			}
+		case external && k == "command":
+			if p.Command, err = cast.ToStringSliceE(v); err != nil || len(p.Command) == 0 {
+				err = fmt.Errorf("plugins.%s.command : expected executable and args,got %#v", id, v)
+				return
+			}
+		case external && k == "dir":
+			if p.Dir, err = cast.ToStringE(v); err != nil {
+				err = fmt.Errorf("plugins.%s.dir : expected string,got %#v", id, v)
+				return
+			}
+		case external && k == "timeout":
+			var timeout int
+			if timeout, err = cast.ToIntE(v); err != nil || timeout <= 0 {
+				err = fmt.Errorf("plugins.%s.timeout : expected seconds,got %#v", id, v)
+				return
+			}
+			p.Timeout = time.Duration(timeout) * time.Second
+		default:
+			p.Settings[k] = v
+		}
+	}
+	if p.Name == "" && !external {
+		p.Name = id
+	}
+	return
+}
+
+//isServiceName returns true if name is a dotted service name,
+//versions are set by the version key.
+func isServiceName(name string) bool {
+	for _, part := range strings.Split(name, ".") {
+		if part == "" || rpc.IsVersion(part) {
+			return false
+		}
+	}
+	return true
+}
+
+//serviceName is the registered name of the plugin service
+func (p PluginConfig) serviceName() string {
+	if p.Version != "" {
+		return p.Name + "." + p.Version
+	}
+	return p.Name
+}
+
+//externalPlugins returns the sorted ids of the external plugins
+func (c *Config) externalPlugins() (ids []string) {
+	for id := range c.Plugins {
+		if section, err := cast.ToStringMapE(c.Plugins[id]); err == nil && section["command"] != nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return
+}
+
+//validatePlugins checks the [plugins.*] sections
+func (c *Config) validatePlugins() (errs []string) {
+	known := map[string]bool{}
+	names := map[string]string{}
+	ids := []string{}
+	for _, p := range plugins {
+		known[p.id] = true
+		ids = append(ids, p.id)
+	}
+	for _, id := range c.externalPlugins() {
+		if known[id] {
+			errs = append(errs, fmt.Sprintf("plugins.%s.command : built-in plugin can not have a command", id))
+			continue
+		}
+		known[id] = true
+		ids = append(ids, id)
+	}
+	builtin := map[string]plugin{}
+	for _, p := range plugins {
+		builtin[p.id] = p
+	}
+	for _, id := range ids {
+		pc, err := c.plugin(id)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if !pc.Enabled {
+			continue
+		}
+		//settings of built-in plugins are checked by Init of a new instance,
+		//which is closed then
+		if p, ok := builtin[id]; ok {
+			instance := p.new()
+			if i, ok := instance.(rpc.Initializer); ok {
+				if err = i.Init(pc.Settings, logger.New(false, nil)); err != nil {
+					errs = append(errs, fmt.Sprintf("plugins.%s : %s", id, err))
+				}
+			}
+			if c, ok := instance.(rpc.Closer); ok {
+				c.Close(context.Background())
+			}
+		}
+		if pc.Name == "" {
+			continue
+		}
+		if other, ok := names[pc.serviceName()]; ok {
+			errs = append(errs, fmt.Sprintf("plugins.%s.name : %q is already used by plugin %s", id, pc.serviceName(), other))
+		}
+		names[pc.serviceName()] = id
+	}
+	unknown := []string{}
+	for id := range c.Plugins {
+		if !known[id] {
+			unknown = append(unknown, id)
+		}
+	}
+	sort.Strings(unknown)
+	for _, id := range unknown {
+		errs = append(errs, fmt.Sprintf("plugins.%s : unknown plugin", id))
+	}
+	return
+}
+
+//registRpcService registers the enabled built-in and external plugins as
+//rpc services,their settings apply on reload
+func registRpcService() (err error) {
+	c := conf()
+	//reinit calls Init of the registered built-in plugins and restarts the
+	//external plugins whose settings changed
+	reinit := map[string]func(settings map[string]interface{}) error{}
+	for _, p := range plugins {
+		var pc PluginConfig
+		if pc, err = c.plugin(p.id); err != nil {
+			return
+		}
+		if !pc.Enabled {
+			log.Infof("plugin %s disabled", p.id)
+			continue
+		}
+		name := pc.serviceName()
+		if err = srv.Register(p.new(), name); err != nil {
+			return fmt.Errorf("plugin %s : register service %q fail : %s", p.id, name, err)
+		}
+		if err = aliasMethods(p.id, name, pc.Aliases); err != nil {
+			return
+		}
+		l := log.With(logger.Fields{"plugin": p.id})
+		if err = srv.Init(name, pc.Settings, l); err != nil {
+			return fmt.Errorf("plugin %s : init fail : %s", p.id, err)
+		}
+		id := p.id
+		reinit[id] = func(settings map[string]interface{}) error {
+			if err := srv.Init(name, settings, l); err != nil {
+				return fmt.Errorf("plugin %s : init with new settings fail : %s", id, err)
+			}
+			log.Infof("plugin %s : new settings applied", id)
+			return nil
+		}
+		log.Infof("plugin %s registered as service %q", p.id, name)
+	}
+	for _, id := range c.externalPlugins() {
+		var pc PluginConfig
+		if pc, err = c.plugin(id); err != nil {
+			return
+		}
+		if !pc.Enabled {
+			log.Infof("plugin %s disabled", id)
+			continue
+		}
+		p := newExternalPlugin(id, pc, log.With(logger.Fields{"plugin": id}))
+		if err = p.start(); err != nil {
+			p.kill()
+			return fmt.Errorf("plugin %s : start fail : %s", id, err)
+		}
+		if pc.Name == "" {
+			pc.Name = p.service
+		}
+		name := pc.serviceName()
+		if err = srv.RegisterProxy(p, name); err != nil {
+			p.kill()
+			return fmt.Errorf("plugin %s : register service %q fail : %s", id, name, err)
+		}
+		if err = aliasMethods(id, name, pc.Aliases); err != nil {
+			p.kill()
+			return
+		}
+		go p.supervise()
+		reinit[id] = func(settings map[string]interface{}) error {
+			log.Infof("plugin %s : settings changed,restarting", p.id)
+			p.reconfigure(settings)
+			return nil
+		}
+		log.Infof("external plugin %s registered as service %q,pid %d", id, name, p.pid())
+	}
+	ids := []string{}
+	for id := range reinit {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	onReload(func(old, new *Config) error {
+		//the plugins applied already get their old settings back on error
+		applied := []string{}
+		for _, id := range ids {
+			o, err1 := old.plugin(id)
+			n, err2 := new.plugin(id)
+			if err1 != nil || err2 != nil || !n.Enabled || reflect.DeepEqual(o.Settings, n.Settings) {
+				continue
+			}
+			if err := reinit[id](n.Settings); err != nil {
+				for i := len(applied) - 1; i >= 0; i-- {
+					o, _ := old.plugin(applied[i])
+					if e := reinit[applied[i]](o.Settings); e != nil {
+						log.Errorf("config reload : roll back fail : %s", e)
+					}
+				}
+				return err
+			}
+			applied = append(applied, id)
+		}
+		return nil
+	})
+	return
+}
+
+//aliasMethods adds the configured aliases of plugin id to the service name
+func aliasMethods(id, name string, aliases map[string]string) (err error) {
+	keys := []string{}
+	for alias := range aliases {
+		keys = append(keys, alias)
+	}
+	sort.Strings(keys)
+	for _, alias := range keys {
+		if err = srv.Alias(name, alias, aliases[alias]); err != nil {
+			return fmt.Errorf("plugin %s : alias %q fail : %s", id, alias, err)
+		}
+	}
+	return
+}
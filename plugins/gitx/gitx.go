@@ -1,6 +1,7 @@
 package gitx
 
 import (
+	"agentX/rpc"
 	"crypto/rand"
 	"crypto/tls"
 	"errors"
@@ -40,6 +41,9 @@ type URL struct {
 	BRANCH     string `json:"branch"`
 	USER       string `json:"user"`
 	PASSWORD   string `json:"password"`
+	//CONFIRM asks the client to confirm before the worktree is reset and
+	//the other branches are deleted,the client must be on websocket
+	CONFIRM bool `json:"confirm"`
 }
 
 func checkout(name string, url URL) (output string, err error) {
@@ -131,7 +135,7 @@ func newLenChars(length int, chars []byte) string {
 		}
 	}
 }
-func (x *Gitx) Publish(in *URL, out *string) (err error) {
+func (x *Gitx) Publish(ctx *rpc.Context, in *URL, out *string) (err error) {
 	url := *in
 	if isEmpty(url.PATH) {
 		err = nil
@@ -151,6 +155,16 @@ func (x *Gitx) Publish(in *URL, out *string) (err error) {
 	if err != nil {
 		return
 	}
+	if url.CONFIRM {
+		var ok bool
+		ok, err = ctx.Confirm(fmt.Sprintf("reset the worktree of %s to %s and delete its other branches?", url.PATH, url.BRANCH), 0)
+		if err != nil {
+			return fmt.Errorf("confirm fail : %s", err)
+		}
+		if !ok {
+			return errors.New("cancelled by the client")
+		}
+	}
 	branchShortName := ""
 	branchShortName, _, err = createBranch(url)
 	if err != nil {
@@ -0,0 +1,31 @@
+//go:build !unix
+
+package systemx
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+//setUser is only supported on unix,commands run as the agent user here
+func setUser(cmd *exec.Cmd, name string) error {
+	if name != "" {
+		return errors.New("user : switching user is not supported on this platform")
+	}
+	return nil
+}
+
+//killGroup kills the process of cmd,there are no process groups here and
+//the children it started outlive the timeout
+func killGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return cmd.Process.Kill()
+}
+
+//exitSignal is only known on unix
+func exitSignal(state *os.ProcessState) string {
+	return ""
+}
@@ -1,17 +1,123 @@
 package systemx
 
 import (
+	"agentX/rpc"
 	"agentX/utils"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
 	"time"
+
+	logger "github.com/snail007/mini-logger"
+	"github.com/spf13/cast"
+)
+
+//ExecTopic is the event topic of the results of async commands
+const ExecTopic = "system.exec"
+
+const (
+	defaultTimeout   = 60
+	defaultMaxOutput = 1024 * 1024
+	//waitDelay is how long Wait waits for the output of a command which
+	//exited or was killed,children left in the background may hold it open
+	waitDelay = time.Second * 2
 )
 
-type SystemX struct{}
+var defaultShell = []string{"/bin/sh", "-c"}
+
+//SystemX is the system plugin,its settings are:
+//  shell = ["/bin/sh","-c"]  runs Command.Cmd
+//  defaultTimeout = 60       seconds of a command without timeout
+//  maxOutput = 1048576       bytes kept of stdout and of stderr
+type SystemX struct {
+	mutex     sync.Mutex //guards the settings,which change on reload,and async
+	shell     []string
+	timeout   int
+	maxOutput int
+	log       logger.MiniLogger
+	async     map[*exec.Cmd]bool //async commands running
+}
+
+//Command is the params of Exec,Cmd is run by the shell unless Args is set
 type Command struct {
-	Cmd     string `json:"cmd"`
-	Async   bool   `json:"async"`
+	Cmd string `json:"cmd"`
+	//Args is the executable and its args,run without a shell
+	Args  []string `json:"args"`
+	Async bool     `json:"async"`
+	//Timeout is the seconds the command may run,the default timeout if 0
 	Timeout int    `json:"timeout"`
 	User    string `json:"user"`
+	//Env is "KEY=value" added to the environment of the agent
+	Env   []string `json:"env"`
+	Dir   string   `json:"dir"`
+	Stdin string   `json:"stdin"`
+}
+
+//Result is the result of Exec,an async command returns only the pid and its
+//result is published with ExecTopic when it ends
+type Result struct {
+	Pid    int    `json:"pid"`
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+	//ExitCode is -1 if the command was killed by a signal
+	ExitCode int `json:"exitCode"`
+	//Signal is the name of the signal which killed the command
+	Signal          string `json:"signal,omitempty"`
+	TimedOut        bool   `json:"timedOut"`
+	DurationMs      int64  `json:"durationMs"`
+	StdoutTruncated bool   `json:"stdoutTruncated"`
+	StderrTruncated bool   `json:"stderrTruncated"`
+}
+
+//Init reads the settings,it is called again with new settings on reload
+func (x *SystemX) Init(settings map[string]interface{}, log logger.MiniLogger) (err error) {
+	shell, timeout, maxOutput := defaultShell, defaultTimeout, defaultMaxOutput
+	if v, ok := setting(settings, "shell"); ok {
+		if shell, err = cast.ToStringSliceE(v); err != nil || len(shell) == 0 {
+			return fmt.Errorf("shell : expected executable and args,got %#v", v)
+		}
+	}
+	if v, ok := setting(settings, "defaultTimeout"); ok {
+		if timeout, err = cast.ToIntE(v); err != nil || timeout <= 0 {
+			return fmt.Errorf("defaultTimeout : expected seconds,got %#v", v)
+		}
+	}
+	if v, ok := setting(settings, "maxOutput"); ok {
+		if maxOutput, err = cast.ToIntE(v); err != nil || maxOutput <= 0 {
+			return fmt.Errorf("maxOutput : expected bytes,got %#v", v)
+		}
+	}
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	x.log = log
+	x.shell, x.timeout, x.maxOutput = shell, timeout, maxOutput
+	return nil
+}
+
+//setting returns the setting key,the keys of the config file are case
+//insensitive
+func setting(settings map[string]interface{}, key string) (v interface{}, ok bool) {
+	for k, v := range settings {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+//Close kills the async commands running
+func (x *SystemX) Close(ctx context.Context) error {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	for cmd := range x.async {
+		killGroup(cmd)
+	}
+	return nil
 }
 
 func (x *SystemX) Passwd(out *string) (err error) {
@@ -22,7 +128,118 @@ func (x *SystemX) Time(out *string) (err error) {
 	*out = fmt.Sprintf("%d", time.Now().Unix())
 	return nil
 }
-func (x *SystemX) Exec(command *Command, out *string) (err error) {
-	*out = fmt.Sprintf("%d", time.Now().Unix())
-	return nil
+
+//Exec runs a command,it is killed with its process group after the timeout
+//or when the client is gone,unless it is async. Windows has no process
+//groups,only the command itself is killed there.
+func (x *SystemX) Exec(ctx *rpc.Context, command *Command, out *Result) (err error) {
+	x.mutex.Lock()
+	shell, timeout, maxOutput := x.shell, x.timeout, x.maxOutput
+	x.mutex.Unlock()
+	argv := command.Args
+	if len(argv) == 0 {
+		if command.Cmd == "" {
+			return errors.New("cmd or args is required")
+		}
+		if len(shell) == 0 {
+			shell = defaultShell
+		}
+		argv = append(append([]string{}, shell...), command.Cmd)
+	}
+	for _, e := range command.Env {
+		if !strings.Contains(e, "=") {
+			return fmt.Errorf("env : expected KEY=value,got %q", e)
+		}
+	}
+	if command.Timeout > 0 {
+		timeout = command.Timeout
+	} else if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	parent := context.Context(ctx)
+	if command.Async {
+		parent = context.Background()
+	}
+	runCtx, cancel := context.WithTimeout(parent, time.Duration(timeout)*time.Second)
+	cmd := exec.CommandContext(runCtx, argv[0], argv[1:]...)
+	cmd.Dir = command.Dir
+	cmd.Env = os.Environ()
+	if command.Stdin != "" {
+		cmd.Stdin = strings.NewReader(command.Stdin)
+	}
+	if err = setUser(cmd, command.User); err != nil {
+		cancel()
+		return
+	}
+	cmd.Env = append(cmd.Env, command.Env...)
+	cmd.Cancel = func() error {
+		return killGroup(cmd)
+	}
+	cmd.WaitDelay = waitDelay
+	if maxOutput <= 0 {
+		maxOutput = defaultMaxOutput
+	}
+	stdout := &limitedBuffer{max: maxOutput}
+	stderr := &limitedBuffer{max: maxOutput}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	started := time.Now()
+	if err = cmd.Start(); err != nil {
+		cancel()
+		return
+	}
+	wait := func() (r Result) {
+		defer cancel()
+		cmd.Wait()
+		r = Result{
+			Pid:             cmd.Process.Pid,
+			Stdout:          stdout.String(),
+			Stderr:          stderr.String(),
+			ExitCode:        cmd.ProcessState.ExitCode(),
+			Signal:          exitSignal(cmd.ProcessState),
+			TimedOut:        runCtx.Err() == context.DeadlineExceeded,
+			DurationMs:      time.Since(started).Milliseconds(),
+			StdoutTruncated: stdout.truncated,
+			StderrTruncated: stderr.truncated,
+		}
+		return
+	}
+	if !command.Async {
+		*out = wait()
+		return
+	}
+	x.mutex.Lock()
+	if x.async == nil {
+		x.async = map[*exec.Cmd]bool{}
+	}
+	x.async[cmd] = true
+	x.mutex.Unlock()
+	out.Pid = cmd.Process.Pid
+	go func() {
+		r := wait()
+		x.mutex.Lock()
+		delete(x.async, cmd)
+		x.mutex.Unlock()
+		ctx.Publish(ExecTopic, r)
+	}()
+	return
+}
+
+//limitedBuffer keeps the first max bytes written to it
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.buf.Len(); len(p) > room {
+		b.truncated = true
+		b.buf.Write(p[:room])
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) String() string {
+	return b.buf.String()
 }
@@ -0,0 +1,100 @@
+package systemx
+
+import (
+	"agentX/rpc"
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	logger "github.com/snail007/mini-logger"
+)
+
+func TestInit(t *testing.T) {
+	tests := []struct {
+		settings map[string]interface{}
+		err      string //a part of the error,empty if valid
+	}{
+		{nil, ""},
+		{map[string]interface{}{"shell": []interface{}{"bash", "-c"}, "DefaultTimeout": 5, "maxoutput": "10"}, ""},
+		{map[string]interface{}{"shell": []interface{}{}}, "shell : "},
+		{map[string]interface{}{"defaultTimeout": 0}, "defaultTimeout : "},
+		{map[string]interface{}{"maxOutput": "x"}, "maxOutput : "},
+	}
+	for _, test := range tests {
+		x := new(SystemX)
+		err := x.Init(test.settings, logger.New(false, nil))
+		if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("Init(%v) = %v,want %q", test.settings, err, test.err)
+		}
+	}
+}
+
+func TestExec(t *testing.T) {
+	x := new(SystemX)
+	if err := x.Init(map[string]interface{}{"maxOutput": 8}, logger.New(false, nil)); err != nil {
+		t.Fatal(err)
+	}
+	uid := strconv.Itoa(os.Getuid())
+	tests := []struct {
+		name    string
+		command Command
+		check   func(r Result) bool
+		err     string //a part of the error,empty if it runs
+	}{
+		{"shell", Command{Cmd: "echo $X; echo e >&2; exit 3", Env: []string{"X=ok"}}, func(r Result) bool {
+			return r.Stdout == "ok\n" && r.Stderr == "e\n" && r.ExitCode == 3 && !r.TimedOut && r.Pid > 0
+		}, ""},
+		{"args", Command{Args: []string{"printf", "%s", "a b"}}, func(r Result) bool {
+			return r.Stdout == "a b" && r.ExitCode == 0
+		}, ""},
+		{"stdin", Command{Cmd: "cat", Stdin: "in"}, func(r Result) bool {
+			return r.Stdout == "in"
+		}, ""},
+		{"dir", Command{Cmd: "pwd", Dir: "/"}, func(r Result) bool {
+			return r.Stdout == "/\n"
+		}, ""},
+		{"truncated", Command{Cmd: "echo 0123456789"}, func(r Result) bool {
+			return r.Stdout == "01234567" && r.StdoutTruncated && !r.StderrTruncated
+		}, ""},
+		{"timeout", Command{Cmd: "sleep 10 & sleep 10", Timeout: 1}, func(r Result) bool {
+			return r.TimedOut && r.ExitCode == -1 && r.Signal == "killed" && r.DurationMs < 3000
+		}, ""},
+		{"user", Command{Cmd: "id -u; echo $HOME", User: uid}, func(r Result) bool {
+			return strings.HasPrefix(r.Stdout, uid+"\n") && r.Stdout != uid+"\n\n"
+		}, ""},
+		{"unknown user", Command{Cmd: "true", User: "no-such-user-x"}, nil, "user : unknown user"},
+		{"no cmd", Command{}, nil, "cmd or args is required"},
+		{"bad env", Command{Cmd: "true", Env: []string{"X"}}, nil, "env : "},
+		{"not found", Command{Args: []string{"/nonexistent"}}, nil, "no such file"},
+	}
+	for _, test := range tests {
+		ctx := &rpc.Context{Context: context.Background()}
+		var r Result
+		err := x.Exec(ctx, &test.command, &r)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s : got error %v,want %q in it", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil || !test.check(r) {
+			t.Errorf("%s : got %+v,%v", test.name, r, err)
+		}
+	}
+}
+
+func TestExecCanceled(t *testing.T) {
+	x := new(SystemX)
+	c, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	var r Result
+	if err := x.Exec(&rpc.Context{Context: c}, &Command{Cmd: "sleep 10"}, &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.TimedOut || r.ExitCode != -1 || r.DurationMs > 3000 {
+		t.Errorf("got %+v,want killed when the call is canceled", r)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	logger "github.com/snail007/mini-logger"
+)
+
+//closeCounter counts the instances which were initialized and closed
+type closeCounter struct {
+	inits, closes *int
+}
+
+func (x *closeCounter) Init(settings map[string]interface{}, log logger.MiniLogger) error {
+	*x.inits++
+	return nil
+}
+
+func (x *closeCounter) Close(ctx context.Context) error {
+	*x.closes++
+	return nil
+}
+
+func (x *closeCounter) Ping(reply *string) error {
+	*reply = "pong"
+	return nil
+}
+
+func TestValidatePluginsCloses(t *testing.T) {
+	inits, closes := 0, 0
+	builtin := plugins
+	plugins = append(plugins, plugin{id: "counter", new: func() interface{} {
+		return &closeCounter{&inits, &closes}
+	}})
+	defer func() {
+		plugins = builtin
+	}()
+	for i := 0; i < 3; i++ {
+		if _, err := testConfig(t, map[string]string{"config.toml": "[plugins.counter]\nx = 1\n"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if inits != 3 || closes != 3 {
+		t.Errorf("%d instances initialized,%d closed,want 3", inits, closes)
+	}
+}
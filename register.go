@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+//registration reports the agent to the endpoint of [register] on startup
+//and every interval,the body is a Registration posted as json,or the params
+//of a json-rpc call of register.method when it is set.
+type registration struct {
+	config RegisterConfig
+	id     string
+	client *http.Client
+	stop   chan bool
+
+	mutex sync.Mutex
+	state RegistrationState
+}
+
+//Registration is what the agent reports about itself
+type Registration struct {
+	ID       string            `json:"id"`
+	Version  string            `json:"version"`
+	Hostname string            `json:"hostname"`
+	OS       string            `json:"os"`
+	Arch     string            `json:"arch"`
+	Kernel   string            `json:"kernel,omitempty"`
+	Distro   string            `json:"distro,omitempty"`
+	Services []string          `json:"services"`
+	Health   string            `json:"health"`
+	Status   map[string]string `json:"status"`
+	Time     time.Time         `json:"time"`
+}
+
+//RegistrationState is the state of the registration client
+type RegistrationState struct {
+	Enabled     bool       `json:"enabled"`
+	ID          string     `json:"id,omitempty"`
+	Endpoint    string     `json:"endpoint,omitempty"`
+	Registered  bool       `json:"registered"`
+	Attempts    int        `json:"attempts"`
+	LastAttempt *time.Time `json:"lastAttempt,omitempty"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+}
+
+//register is the registration client,nil if not configured
+var register *registration
+
+func newRegistration(config RegisterConfig) (r *registration, err error) {
+	id, err := loadAgentID(config.IDFile)
+	if err != nil {
+		return
+	}
+	r = &registration{
+		config: config,
+		id:     id,
+		client: &http.Client{Timeout: time.Second * 30},
+		stop:   make(chan bool),
+		state:  RegistrationState{Enabled: true, ID: id, Endpoint: config.URL},
+	}
+	return
+}
+
+//loadAgentID returns the agent id stored in file,a new random id is
+//stored when the file does not exist yet.
+func loadAgentID(file string) (id string, err error) {
+	b, err := ioutil.ReadFile(file)
+	if err == nil {
+		if id = strings.TrimSpace(string(b)); id != "" {
+			return
+		}
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("read agent id fail : %s", err)
+	}
+	buf := make([]byte, 16)
+	if _, err = rand.Read(buf); err != nil {
+		return
+	}
+	id = hex.EncodeToString(buf)
+	if err = os.MkdirAll(filepath.Dir(file), 0755); err == nil {
+		err = ioutil.WriteFile(file, []byte(id+"\n"), 0644)
+	}
+	if err != nil {
+		return "", fmt.Errorf("save agent id fail : %s", err)
+	}
+	return
+}
+
+//run registers now and every interval until Close,failed attempts are
+//retried sooner with a growing interval.
+func (r *registration) run() {
+	interval := time.Duration(r.config.Interval) * time.Second
+	minRetry := time.Second * 5
+	if minRetry > interval {
+		minRetry = interval
+	}
+	retry := minRetry
+	for {
+		next := interval
+		if err := r.register(); err != nil {
+			log.Warnf("register to %s fail : %s,retry after %s", r.config.URL, err, retry)
+			next = retry
+			if retry *= 2; retry > interval {
+				retry = interval
+			}
+		} else {
+			retry = minRetry
+		}
+		select {
+		case <-r.stop:
+			return
+		case <-time.After(next):
+		}
+	}
+}
+
+//register sends the report once
+func (r *registration) register() (err error) {
+	report := r.report()
+	r.mutex.Lock()
+	r.state.Attempts++
+	r.state.LastAttempt = &report.Time
+	r.mutex.Unlock()
+	defer func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		if err != nil {
+			r.state.Registered = false
+			r.state.LastError = err.Error()
+			return
+		}
+		if !r.state.Registered {
+			log.Infof("registered to %s as %s", r.config.URL, r.id)
+		}
+		r.state.Registered = true
+		now := time.Now()
+		r.state.LastSuccess = &now
+		r.state.LastError = ""
+	}()
+	var body interface{} = report
+	if r.config.Method != "" {
+		body = map[string]interface{}{"jsonrpc": "2.0", "method": r.config.Method, "params": report, "id": 1}
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest("POST", r.config.URL, bytes.NewReader(b))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.config.Token)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	if r.config.Method != "" {
+		var response struct {
+			Error *struct {
+				Message interface{} `json:"message"`
+			} `json:"error"`
+		}
+		if err = json.Unmarshal(data, &response); err != nil {
+			return fmt.Errorf("bad response : %s", err)
+		}
+		if response.Error != nil {
+			return fmt.Errorf("%v", response.Error.Message)
+		}
+	}
+	return
+}
+
+//report collects the facts of the agent
+func (r *registration) report() (report Registration) {
+	report.ID = r.id
+	report.Version = version
+	report.Hostname, _ = os.Hostname()
+	report.OS = runtime.GOOS
+	report.Arch = runtime.GOARCH
+	if b, err := ioutil.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		report.Kernel = strings.TrimSpace(string(b))
+	}
+	report.Distro = osRelease()
+	report.Services = []string{}
+	for _, s := range srv.Describe() {
+		report.Services = append(report.Services, s.Name)
+	}
+	healthy, status := srv.Health()
+	report.Health = "ok"
+	if !healthy {
+		report.Health = "degraded"
+	}
+	report.Status = status
+	report.Time = time.Now()
+	return
+}
+
+//osRelease returns PRETTY_NAME of /etc/os-release
+func osRelease() string {
+	b, err := ioutil.ReadFile("/etc/os-release")
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(line, "PRETTY_NAME=") {
+			return strings.Trim(strings.TrimPrefix(line, "PRETTY_NAME="), `"`)
+		}
+	}
+	return ""
+}
+
+//State returns the registration state
+func (r *registration) State() RegistrationState {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.state
+}
+
+//Close stops registering
+func (r *registration) Close() {
+	close(r.stop)
+}
+
+//validate checks [register]
+func (r RegisterConfig) validate() (errs []string) {
+	if r.URL == "" {
+		return
+	}
+	if u, err := url.Parse(r.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Sprintf("register.url : expected http:// or https:// url,got %q", r.URL))
+	}
+	if r.Interval <= 0 {
+		errs = append(errs, "register.interval : should be greater than 0")
+	}
+	if r.IDFile == "" {
+		errs = append(errs, "register.idFile : should not be empty")
+	}
+	return
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const maxReconnectInterval = time.Minute
+
+//reverseClient dials the controller of [reverse] and serves the rpc calls
+//it sends over that websocket,for agents which can not be reached.
+//the agent authenticates with the headers:
+//  Authorization: Bearer <token>
+//  X-AgentX-Id: <id>
+//  X-AgentX-Version: <version>
+//then the controller sends newline-delimited json-rpc requests like a
+//websocket client of rpc.listen. The agent pings every heartbeat and
+//reconnects with backoff when the link is lost.
+type reverseClient struct {
+	config ReverseConfig
+	dialer *websocket.Dialer
+
+	mutex   sync.Mutex
+	conn    *websocket.Conn
+	stopped bool
+	stop    chan bool
+}
+
+func newReverseClient(config ReverseConfig) (c *reverseClient, err error) {
+	tlsConfig, err := config.tlsConfig()
+	if err != nil {
+		return
+	}
+	c = &reverseClient{
+		config: config,
+		dialer: &websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			TLSClientConfig:  tlsConfig,
+			HandshakeTimeout: time.Second * 10,
+		},
+		stop: make(chan bool),
+	}
+	return
+}
+
+//run connects and serves until Close,the interval between connects grows
+//while the controller can not be reached.
+func (c *reverseClient) run() {
+	interval := time.Second
+	for {
+		started := time.Now()
+		err := c.serve()
+		if c.isStopped() {
+			return
+		}
+		if time.Since(started) > maxReconnectInterval {
+			interval = time.Second
+		}
+		log.Warnf("controller link lost : %s,reconnect after %s", err, interval)
+		select {
+		case <-c.stop:
+			return
+		case <-time.After(interval):
+		}
+		if interval *= 2; interval > maxReconnectInterval {
+			interval = maxReconnectInterval
+		}
+	}
+}
+
+//serve dials the controller and serves the link until it breaks
+func (c *reverseClient) serve() (err error) {
+	header := http.Header{}
+	if c.config.Token != "" {
+		header.Set("Authorization", "Bearer "+c.config.Token)
+	}
+	header.Set("X-AgentX-Id", c.config.agentID())
+	header.Set("X-AgentX-Version", version)
+	conn, resp, err := c.dialer.Dial(c.config.URL, header)
+	if err != nil {
+		if resp != nil {
+			err = fmt.Errorf("%s : %s", err, resp.Status)
+		}
+		return
+	}
+	c.mutex.Lock()
+	if c.stopped {
+		c.mutex.Unlock()
+		conn.Close()
+		return
+	}
+	c.conn = conn
+	c.mutex.Unlock()
+	log.Infof("connected to controller %s", c.config.URL)
+
+	heartbeat := time.Duration(c.config.Heartbeat) * time.Second
+	conn.SetReadDeadline(time.Now().Add(heartbeat * 3))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(heartbeat * 3))
+	})
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(heartbeat)); err != nil {
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
+	//calls from the controller are trusted,it is the one we authenticated to
+	r, _ := http.NewRequest("GET", c.config.URL, nil)
+	r.RemoteAddr = conn.RemoteAddr().String()
+	srv.ServeWS(conn, r)
+	return fmt.Errorf("connection closed")
+}
+
+func (c *reverseClient) isStopped() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.stopped
+}
+
+//Close stops reconnecting and closes the link
+func (c *reverseClient) Close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.stopped {
+		return
+	}
+	c.stopped = true
+	close(c.stop)
+	if c.conn != nil {
+		c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(time.Second))
+		c.conn.Close()
+	}
+}
+
+//agentID is the configured id or the hostname
+func (r ReverseConfig) agentID() string {
+	if r.ID != "" {
+		return r.ID
+	}
+	host, _ := os.Hostname()
+	return host
+}
+
+//tlsConfig returns the tls config to dial the controller
+func (r ReverseConfig) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: r.InsecureSkipVerify}
+	if r.CAFile != "" {
+		pem, err := ioutil.ReadFile(r.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", r.CAFile)
+		}
+	}
+	return config, nil
+}
+
+//validate checks [reverse]
+func (r ReverseConfig) validate() (errs []string) {
+	if r.URL == "" {
+		return
+	}
+	if u, err := url.Parse(r.URL); err != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+		errs = append(errs, fmt.Sprintf("reverse.url : expected ws:// or wss:// url,got %q", r.URL))
+	}
+	if r.Heartbeat <= 0 {
+		errs = append(errs, "reverse.heartbeat : should be greater than 0")
+	}
+	if _, err := r.tlsConfig(); err != nil {
+		errs = append(errs, fmt.Sprintf("reverse.caFile : %s", err))
+	}
+	return
+}
@@ -0,0 +1,339 @@
+package rpc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// CBOR,see RFC 8949. Tags are decoded as their content.
+
+var cborCodec = &codec{
+	name:         "cbor",
+	contentTypes: []string{"application/cbor"},
+	newEncoder:   func() encoder { return new(cborEncoder) },
+	decode:       decodeCBOR,
+}
+
+// cbor major types
+const (
+	cborUint   = 0 << 5
+	cborNegInt = 1 << 5
+	cborBytes  = 2 << 5
+	cborText   = 3 << 5
+	cborArray  = 4 << 5
+	cborMap    = 5 << 5
+	cborTag    = 6 << 5
+	cborSimple = 7 << 5
+)
+
+type cborEncoder struct {
+	buf []byte
+}
+
+func (e *cborEncoder) bytes() []byte {
+	return e.buf
+}
+
+// head writes the initial byte of major with the argument u.
+func (e *cborEncoder) head(major byte, u uint64) {
+	switch {
+	case u < 24:
+		e.buf = append(e.buf, major|byte(u))
+	case u <= math.MaxUint8:
+		e.buf = append(e.buf, major|24, byte(u))
+	case u <= math.MaxUint16:
+		e.buf = append(e.buf, major|25)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(u))
+	case u <= math.MaxUint32:
+		e.buf = append(e.buf, major|26)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(u))
+	default:
+		e.buf = append(e.buf, major|27)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, u)
+	}
+}
+
+func (e *cborEncoder) writeNil() {
+	e.buf = append(e.buf, cborSimple|22)
+}
+
+func (e *cborEncoder) writeBool(b bool) {
+	if b {
+		e.buf = append(e.buf, cborSimple|21)
+	} else {
+		e.buf = append(e.buf, cborSimple|20)
+	}
+}
+
+func (e *cborEncoder) writeInt(i int64) {
+	if i >= 0 {
+		e.head(cborUint, uint64(i))
+		return
+	}
+	e.head(cborNegInt, uint64(-1-i))
+}
+
+func (e *cborEncoder) writeUint(u uint64) {
+	e.head(cborUint, u)
+}
+
+func (e *cborEncoder) writeFloat(f float64) {
+	e.buf = append(e.buf, cborSimple|27)
+	e.buf = binary.BigEndian.AppendUint64(e.buf, math.Float64bits(f))
+}
+
+func (e *cborEncoder) writeString(s string) {
+	e.head(cborText, uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *cborEncoder) writeBytes(b []byte) {
+	e.head(cborBytes, uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *cborEncoder) writeArrayHeader(n int) {
+	e.head(cborArray, uint64(n))
+}
+
+func (e *cborEncoder) writeMapHeader(n int) {
+	e.head(cborMap, uint64(n))
+}
+
+// cborDecoder reads values from data.
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+// cborBreak ends indefinite length items
+type cborBreak struct{}
+
+func decodeCBOR(data []byte) (interface{}, error) {
+	d := &cborDecoder{data: data}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := v.(cborBreak); ok {
+		return nil, fmt.Errorf("rpc: unexpected cbor break")
+	}
+	if d.pos != len(d.data) {
+		return nil, fmt.Errorf("rpc: %d bytes after cbor value", len(d.data)-d.pos)
+	}
+	return v, nil
+}
+
+func (d *cborDecoder) next(n uint64) ([]byte, error) {
+	if uint64(len(d.data)-d.pos) < n {
+		return nil, errShort
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// arg reads the argument of the initial byte c,indefinite is true for
+// the indefinite length of strings,arrays and maps.
+func (d *cborDecoder) arg(c byte) (u uint64, indefinite bool, err error) {
+	info := c & 0x1f
+	switch {
+	case info < 24:
+		return uint64(info), false, nil
+	case info <= 27:
+		b, err := d.next(1 << (info - 24))
+		if err != nil {
+			return 0, false, err
+		}
+		for _, x := range b {
+			u = u<<8 | uint64(x)
+		}
+		return u, false, nil
+	case info == 31:
+		return 0, true, nil
+	}
+	return 0, false, fmt.Errorf("rpc: invalid cbor byte 0x%02x", c)
+}
+
+func (d *cborDecoder) value(depth int) (interface{}, error) {
+	if depth > maxDecodeDepth {
+		return nil, errDepth
+	}
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+	if c == 0xff {
+		return cborBreak{}, nil
+	}
+	major := c & 0xe0
+	if major == cborSimple {
+		return d.simple(c)
+	}
+	u, indefinite, err := d.arg(c)
+	if err != nil {
+		return nil, err
+	}
+	if indefinite && (major == cborUint || major == cborNegInt || major == cborTag) {
+		return nil, fmt.Errorf("rpc: invalid cbor byte 0x%02x", c)
+	}
+	switch major {
+	case cborUint:
+		if u <= math.MaxInt64 {
+			return int64(u), nil
+		}
+		return u, nil
+	case cborNegInt:
+		if u > math.MaxInt64 {
+			return -1 - float64(u), nil
+		}
+		return -1 - int64(u), nil
+	case cborBytes, cborText:
+		var s []byte
+		if indefinite {
+			s, err = d.chunks(major, depth)
+		} else {
+			var chunk []byte
+			chunk, err = d.next(u)
+			s = append([]byte{}, chunk...)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if major == cborText {
+			return string(s), nil
+		}
+		return s, nil
+	case cborArray:
+		if !indefinite && u > uint64(len(d.data)-d.pos) {
+			return nil, errShort
+		}
+		a := []interface{}{}
+		for i := uint64(0); indefinite || i < u; i++ {
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := v.(cborBreak); ok {
+				if !indefinite {
+					return nil, fmt.Errorf("rpc: unexpected cbor break")
+				}
+				break
+			}
+			a = append(a, v)
+		}
+		return a, nil
+	case cborMap:
+		if !indefinite && 2*u > uint64(len(d.data)-d.pos) {
+			return nil, errShort
+		}
+		m := map[string]interface{}{}
+		for i := uint64(0); indefinite || i < u; i++ {
+			k, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := k.(cborBreak); ok {
+				if !indefinite {
+					return nil, fmt.Errorf("rpc: unexpected cbor break")
+				}
+				break
+			}
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := v.(cborBreak); ok {
+				return nil, fmt.Errorf("rpc: unexpected cbor break")
+			}
+			m[mapKeyString(k)] = v
+		}
+		return m, nil
+	}
+	// a tag,the content is the value
+	v, err := d.value(depth + 1)
+	if _, ok := v.(cborBreak); ok {
+		return nil, fmt.Errorf("rpc: unexpected cbor break")
+	}
+	return v, err
+}
+
+// chunks reads the chunks of an indefinite length string of major.
+func (d *cborDecoder) chunks(major byte, depth int) (s []byte, err error) {
+	for {
+		b, err := d.next(1)
+		if err != nil {
+			return nil, err
+		}
+		if b[0] == 0xff {
+			return s, nil
+		}
+		if b[0]&0xe0 != major {
+			return nil, fmt.Errorf("rpc: invalid cbor chunk 0x%02x", b[0])
+		}
+		n, indefinite, err := d.arg(b[0])
+		if err != nil {
+			return nil, err
+		}
+		if indefinite {
+			return nil, fmt.Errorf("rpc: nested indefinite cbor string")
+		}
+		chunk, err := d.next(n)
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, chunk...)
+	}
+}
+
+func (d *cborDecoder) simple(c byte) (interface{}, error) {
+	switch c & 0x1f {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		b, err := d.next(2)
+		if err != nil {
+			return nil, err
+		}
+		return halfFloat(binary.BigEndian.Uint16(b)), nil
+	case 26:
+		b, err := d.next(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 27:
+		b, err := d.next(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	}
+	return nil, fmt.Errorf("rpc: unsupported cbor simple value 0x%02x", c)
+}
+
+// halfFloat returns the float64 of an IEEE 754 half precision float.
+func halfFloat(h uint16) float64 {
+	sign := 1.0
+	if h&0x8000 != 0 {
+		sign = -1
+	}
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	switch exp {
+	case 0:
+		return sign * math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			return sign * math.Inf(1)
+		}
+		return math.NaN()
+	}
+	return sign * math.Ldexp(mant+1024, exp-25)
+}
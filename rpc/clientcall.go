@@ -0,0 +1,232 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// calls of the client
+//
+// over websocket and raw tcp the server may call the client too,it sends
+// requests with ids of its own,counted apart from the ids of the client,
+// and the client answers with responses,which have an id and no method:
+//   <- {"jsonrpc":"2.0","method":"rpc.confirm","params":{"message":"delete branches?"},"id":1}
+//   -> {"jsonrpc":"2.0","result":true,"id":1}
+// the calls of a connection are served one by one meanwhile,the responses
+// of the client are read while a method waits for them.
+
+// ConfirmMethod is the method Context.Confirm calls on the client,params
+// is {"message":<message>} and the result should be a bool.
+const ConfirmMethod = "rpc.confirm"
+
+// DefaultClientCallTimeout is the timeout of Context.CallClient if none is given.
+const DefaultClientCallTimeout = time.Second * 30
+
+// ErrNoClientCalls is returned by Context.CallClient when the transport of
+// the call can't carry requests to the client,like plain http.
+var ErrNoClientCalls = errors.New("rpc: the client can't be called on this transport")
+
+// ErrClientGone fails the calls of the client when its connection is closed.
+var ErrClientGone = errors.New("rpc: client disconnected")
+
+// clientCaller sends the requests of the server to the client of a
+// connection and passes them the responses the read loop finds.
+type clientCaller struct {
+	cd    *codec // nil for json lines
+	write func(data []byte) error
+
+	mutex   sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan *clientResponse
+	closed  bool
+}
+
+type clientResponse struct {
+	Result *json.RawMessage `json:"result"`
+	Error  *RPCError        `json:"error"`
+}
+
+func newClientCaller(cd *codec, write func(data []byte) error) *clientCaller {
+	return &clientCaller{cd: cd, write: write, pending: map[uint64]chan *clientResponse{}}
+}
+
+// call calls method of the client and decodes the result into reply,which
+// may be nil to discard it.
+func (cc *clientCaller) call(ctx context.Context, method string, params, reply interface{}, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = DefaultClientCallTimeout
+	}
+	ch := make(chan *clientResponse, 1)
+	cc.mutex.Lock()
+	if cc.closed {
+		cc.mutex.Unlock()
+		return ErrClientGone
+	}
+	cc.nextID++
+	id := cc.nextID
+	cc.pending[id] = ch
+	cc.mutex.Unlock()
+	defer func() {
+		cc.mutex.Lock()
+		delete(cc.pending, id)
+		cc.mutex.Unlock()
+	}()
+	request := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params, "id": id}
+	var data []byte
+	var err error
+	if cc.cd != nil {
+		data, err = cc.cd.marshal(request)
+	} else {
+		data, err = json.Marshal(request)
+		data = append(data, '\n')
+	}
+	if err != nil {
+		return err
+	}
+	if err = cc.write(data); err != nil {
+		return err
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r, ok := <-ch:
+		if !ok {
+			return ErrClientGone
+		}
+		if r.Error != nil {
+			return r.Error
+		}
+		if reply == nil || r.Result == nil {
+			return nil
+		}
+		return json.Unmarshal(*r.Result, reply)
+	case <-timer.C:
+		return fmt.Errorf("rpc: call %s of the client timeout after %s", method, timeout)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// respond passes message to the call it answers and returns true if it
+// is a response,otherwise it is a request of the client.
+func (cc *clientCaller) respond(message []byte) bool {
+	var fields map[string]json.RawMessage
+	if cc.cd != nil {
+		v, err := cc.cd.decode(message)
+		if err != nil {
+			return false
+		}
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		fields = map[string]json.RawMessage{}
+		for k, v := range m {
+			if fields[k], err = json.Marshal(v); err != nil {
+				return false
+			}
+		}
+	} else if json.Unmarshal(message, &fields) != nil {
+		return false
+	}
+	if _, ok := fields["method"]; ok {
+		return false
+	}
+	rawID, ok := fields["id"]
+	if !ok {
+		return false
+	}
+	var id uint64
+	if json.Unmarshal(rawID, &id) != nil {
+		// not an id of ours,the response is dropped
+		return true
+	}
+	r := new(clientResponse)
+	if result, ok := fields["result"]; ok {
+		r.Result = &result
+	}
+	if e, ok := fields["error"]; ok && string(e) != "null" {
+		r.Error = new(RPCError)
+		if json.Unmarshal(e, r.Error) != nil {
+			r.Error = &RPCError{Code: E_INTERNAL, Message: "bad error of the client : " + string(e)}
+		}
+	}
+	cc.mutex.Lock()
+	ch := cc.pending[id]
+	delete(cc.pending, id)
+	cc.mutex.Unlock()
+	if ch != nil {
+		ch <- r
+	}
+	return true
+}
+
+// close fails the calls in flight with ErrClientGone.
+func (cc *clientCaller) close() {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	cc.closed = true
+	for id, ch := range cc.pending {
+		close(ch)
+		delete(cc.pending, id)
+	}
+}
+
+// callQueue runs the calls of a connection one by one in the order they
+// came,apart from the read loop,which keeps passing the responses of the
+// client to the methods waiting for them.
+type callQueue struct {
+	ctx     context.Context
+	calls   chan func()
+	drained chan bool // closed when the calls queued before close are done
+
+	mutex  sync.Mutex
+	closed bool
+}
+
+func newCallQueue(ctx context.Context) *callQueue {
+	q := &callQueue{ctx: ctx, calls: make(chan func(), 16), drained: make(chan bool)}
+	go func() {
+		defer close(q.drained)
+		for f := range q.calls {
+			if q.ctx.Err() == nil {
+				f()
+			}
+		}
+	}()
+	return q
+}
+
+// push queues f,it blocks while the queue is full. f is dropped if the
+// queue is closed.
+func (q *callQueue) push(f func()) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.closed {
+		return
+	}
+	select {
+	case q.calls <- f:
+	case <-q.ctx.Done():
+	}
+}
+
+// close ends the queue after the calls queued,which are skipped once ctx
+// is done. It may be called more than once.
+func (q *callQueue) close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if !q.closed {
+		q.closed = true
+		close(q.calls)
+	}
+}
+
+// wait waits for the calls queued before close.
+func (q *callQueue) wait() {
+	<-q.drained
+}
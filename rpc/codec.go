@@ -0,0 +1,424 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// binary encodings
+//
+// besides json the envelopes can be encoded with MessagePack or CBOR,
+// negotiated with the websocket subprotocol "msgpack" or "cbor",or the
+// http Content-Type application/msgpack or application/cbor. On a websocket
+// of such a subprotocol each binary message is one request or batch and is
+// answered with binary messages,text messages are still json. The values
+// are those of encoding/json,struct fields are named by their json tags,
+// but []byte is sent as binary instead of base64 and binary params are
+// decoded into []byte fields.
+
+// maxDecodeDepth bounds the nesting of decoded values
+const maxDecodeDepth = 256
+
+var errDepth = errors.New("rpc: value nested too deep")
+
+// codec is a binary encoding of the envelopes.
+type codec struct {
+	// name is the websocket subprotocol
+	name string
+	// contentTypes are the http content types,the first is sent
+	contentTypes []string
+	newEncoder   func() encoder
+	// decode decodes one value of data to the values of encoding/json,
+	// map[string]interface{},[]interface{},string,bool,nil and numbers,
+	// binary strings are []byte
+	decode func(data []byte) (interface{}, error)
+}
+
+// encoder writes the values of a codec.
+type encoder interface {
+	writeNil()
+	writeBool(b bool)
+	writeInt(i int64)
+	writeUint(u uint64)
+	writeFloat(f float64)
+	writeString(s string)
+	writeBytes(b []byte)
+	writeArrayHeader(n int)
+	writeMapHeader(n int)
+	bytes() []byte
+}
+
+var codecs = []*codec{msgpackCodec, cborCodec}
+
+// subprotocols are the websocket subprotocols of the codecs
+func subprotocols() (names []string) {
+	for _, c := range codecs {
+		names = append(names, c.name)
+	}
+	return
+}
+
+// codecByName returns the codec of a websocket subprotocol,nil for json.
+func codecByName(name string) *codec {
+	for _, c := range codecs {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// codecOf returns the codec of the Content-Type of r,or of its Accept
+// header if it has no body,nil for json.
+func codecOf(r *http.Request) *codec {
+	header := r.Header.Get("Content-Type")
+	if header == "" && (r.Method == "GET" || r.ContentLength == 0) {
+		header = r.Header.Get("Accept")
+	}
+	for _, value := range strings.Split(header, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		for _, c := range codecs {
+			for _, t := range c.contentTypes {
+				if t == mediaType {
+					return c
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// marshal encodes v like json.Marshal does.
+func (c *codec) marshal(v interface{}) (data []byte, err error) {
+	e := c.newEncoder()
+	if err = encodeValue(e, reflect.ValueOf(v), 0); err != nil {
+		return nil, err
+	}
+	return e.bytes(), nil
+}
+
+// toJSON transcodes data to json,binary strings become the base64 strings
+// encoding/json decodes into []byte.
+func (c *codec) toJSON(data []byte) ([]byte, error) {
+	v, err := c.decode(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	numberType        = reflect.TypeOf(json.Number(""))
+)
+
+// encodeValue writes v to e as encoding/json would encode it.
+func encodeValue(e encoder, v reflect.Value, depth int) error {
+	if depth > maxDecodeDepth {
+		return errDepth
+	}
+	if !v.IsValid() {
+		e.writeNil()
+		return nil
+	}
+	t := v.Type()
+	if t == numberType {
+		return encodeNumber(e, json.Number(v.String()))
+	}
+	if t.Implements(jsonMarshalerType) && !(t.Kind() == reflect.Ptr && v.IsNil()) {
+		raw, err := v.Interface().(json.Marshaler).MarshalJSON()
+		if err != nil {
+			return err
+		}
+		return encodeJSON(e, raw, depth)
+	}
+	if v.CanAddr() && reflect.PtrTo(t).Implements(jsonMarshalerType) {
+		return encodeValue(e, v.Addr(), depth)
+	}
+	if t.Implements(textMarshalerType) && !(t.Kind() == reflect.Ptr && v.IsNil()) {
+		text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return err
+		}
+		e.writeString(string(text))
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		e.writeBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.writeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.writeUint(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		e.writeFloat(v.Float())
+	case reflect.String:
+		e.writeString(v.String())
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			e.writeNil()
+			return nil
+		}
+		return encodeValue(e, v.Elem(), depth+1)
+	case reflect.Slice:
+		if v.IsNil() {
+			e.writeNil()
+			return nil
+		}
+		if t.Elem().Kind() == reflect.Uint8 {
+			e.writeBytes(v.Bytes())
+			return nil
+		}
+		fallthrough
+	case reflect.Array:
+		e.writeArrayHeader(v.Len())
+		for i := 0; i < v.Len(); i++ {
+			if err := encodeValue(e, v.Index(i), depth+1); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			e.writeNil()
+			return nil
+		}
+		keys := make([]string, 0, v.Len())
+		values := map[string]reflect.Value{}
+		for _, k := range v.MapKeys() {
+			name, err := mapKey(k)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, name)
+			values[name] = v.MapIndex(k)
+		}
+		sort.Strings(keys)
+		e.writeMapHeader(len(keys))
+		for _, k := range keys {
+			e.writeString(k)
+			if err := encodeValue(e, values[k], depth+1); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		fields := cachedFields(t)
+		present := make([]reflect.Value, len(fields))
+		n := 0
+		for i, f := range fields {
+			fv, ok := fieldByIndex(v, f.index)
+			if !ok || (f.omitEmpty && isEmptyValue(fv)) {
+				continue
+			}
+			present[i] = fv
+			n++
+		}
+		e.writeMapHeader(n)
+		for i, f := range fields {
+			if !present[i].IsValid() {
+				continue
+			}
+			e.writeString(f.name)
+			if f.quoted {
+				if err := encodeQuoted(e, present[i]); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := encodeValue(e, present[i], depth+1); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("rpc: can't encode %s", t)
+	}
+	return nil
+}
+
+// encodeJSON writes the json value raw to e.
+func encodeJSON(e encoder, raw []byte, depth int) error {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return err
+	}
+	return encodeValue(e, reflect.ValueOf(v), depth+1)
+}
+
+func encodeNumber(e encoder, n json.Number) error {
+	if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+		e.writeInt(i)
+		return nil
+	}
+	if u, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+		e.writeUint(u)
+		return nil
+	}
+	f, err := strconv.ParseFloat(string(n), 64)
+	if err != nil {
+		return err
+	}
+	e.writeFloat(f)
+	return nil
+}
+
+// encodeQuoted writes the value of a field tagged ",string" as a string.
+func encodeQuoted(e encoder, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.String:
+		raw, err := json.Marshal(v.Interface())
+		if err != nil {
+			return err
+		}
+		e.writeString(string(raw))
+		return nil
+	}
+	return encodeValue(e, v, 0)
+}
+
+// mapKey returns the json object key of a map key.
+func mapKey(k reflect.Value) (string, error) {
+	if k.Kind() == reflect.String {
+		return k.String(), nil
+	}
+	if tm, ok := k.Interface().(encoding.TextMarshaler); ok {
+		text, err := tm.MarshalText()
+		return string(text), err
+	}
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), nil
+	}
+	return "", fmt.Errorf("rpc: can't encode map key %s", k.Type())
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// field is an encoded struct field.
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+	quoted    bool
+}
+
+var fieldCache sync.Map // reflect.Type -> []field
+
+// cachedFields returns the fields encoding/json encodes of the struct t.
+func cachedFields(t reflect.Type) []field {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.([]field)
+	}
+	fields := structFields(t, nil, map[string]bool{})
+	fieldCache.Store(t, fields)
+	return fields
+}
+
+// structFields returns the fields of t,the fields of embedded structs
+// without name are promoted unless shadowed.
+func structFields(t reflect.Type, index []int, seen map[string]bool) (fields []field) {
+	embedded := []reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if j := strings.Index(tag, ","); j >= 0 {
+			name, opts = tag[:j], tag[j:]
+		}
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			embedded = append(embedded, sf)
+			continue
+		}
+		if sf.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		fields = append(fields, field{
+			name:      name,
+			index:     append(append([]int{}, index...), i),
+			omitEmpty: strings.Contains(opts, ",omitempty"),
+			quoted:    strings.Contains(opts, ",string"),
+		})
+	}
+	for _, sf := range embedded {
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		fields = append(fields, structFields(ft, append(append([]int{}, index...), sf.Index[0]), seen)...)
+	}
+	return
+}
+
+// fieldByIndex returns the field of v at index,false if it is behind a
+// nil embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// mapKeyString returns the json object key of a decoded map key.
+func mapKeyString(k interface{}) string {
+	switch k := k.(type) {
+	case string:
+		return k
+	case []byte:
+		return string(k)
+	}
+	return fmt.Sprint(k)
+}
@@ -0,0 +1,151 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+type codecTestStruct struct {
+	Name    string            `json:"name"`
+	Count   int               `json:"count,omitempty"`
+	Skipped string            `json:"-"`
+	Tags    []string          `json:"tags"`
+	Raw     json.RawMessage   `json:"raw"`
+	Data    []byte            `json:"data"`
+	Nested  *codecTestStruct  `json:"nested,omitempty"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// jsonValue decodes data the way the server does,for comparisons.
+func jsonValue(t *testing.T, data []byte) interface{} {
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		t.Fatalf("bad json %s : %s", data, err)
+	}
+	return v
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	values := []interface{}{
+		nil,
+		true,
+		false,
+		0,
+		-1,
+		-33,
+		127,
+		-129,
+		1 << 40,
+		int64(math.MinInt64),
+		uint64(math.MaxUint64),
+		1.5,
+		-0.25,
+		"",
+		"héllo",
+		string(bytes.Repeat([]byte("x"), 70000)),
+		[]byte{0, 1, 2},
+		[]interface{}{1, "a", nil, []int{2}},
+		map[string]interface{}{"a": 1, "b": map[string]bool{"c": true}},
+		map[int]string{1: "one"},
+		json.RawMessage(`{"x":[1,2.5,"y"]}`),
+		codecTestStruct{Name: "n", Skipped: "s", Tags: []string{"a"}, Raw: json.RawMessage(`true`), Data: []byte("d"),
+			Nested: &codecTestStruct{Name: "child"}, Labels: map[string]string{"k": "v"}},
+		map[string]interface{}{"jsonrpc": "2.0", "method": "system.Exec", "params": map[string]string{"cmd": "ls"}, "id": 1},
+	}
+	for _, c := range codecs {
+		for _, v := range values {
+			want, err := json.Marshal(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := c.marshal(v)
+			if err != nil {
+				t.Errorf("%s : marshal %#v : %s", c.name, v, err)
+				continue
+			}
+			got, err := c.toJSON(data)
+			if err != nil {
+				t.Errorf("%s : decode %#v : %s", c.name, v, err)
+				continue
+			}
+			if !reflect.DeepEqual(jsonValue(t, got), jsonValue(t, want)) {
+				t.Errorf("%s : %#v decoded to %s,want %s", c.name, v, got, want)
+			}
+		}
+	}
+}
+
+func TestCodecDecode(t *testing.T) {
+	tests := []struct {
+		codec *codec
+		data  []byte
+		json  string // empty if decoding fails
+	}{
+		{msgpackCodec, []byte{0x81, 0xa1, 'a', 0x01}, `{"a":1}`},
+		{msgpackCodec, []byte{0x92, 0xc3, 0xc0}, `[true,null]`},
+		{msgpackCodec, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}, `1.5`},
+		{msgpackCodec, []byte{0xc4, 0x02, 'h', 'i'}, `"aGk="`},
+		{msgpackCodec, []byte{0x81, 0xa1, 'a'}, ""},
+		{msgpackCodec, []byte{0xa5, 'a'}, ""},
+		{msgpackCodec, []byte{0x01, 0x02}, ""},
+		{msgpackCodec, []byte{0xdd, 0xff, 0xff, 0xff, 0xff}, ""},
+		{msgpackCodec, bytes.Repeat([]byte{0x91}, maxDecodeDepth+2), ""},
+		{cborCodec, []byte{0xa1, 0x61, 'a', 0x01}, `{"a":1}`},
+		{cborCodec, []byte{0x82, 0xf5, 0xf6}, `[true,null]`},
+		{cborCodec, []byte{0x20}, `-1`},
+		{cborCodec, []byte{0xf9, 0x3e, 0x00}, `1.5`},
+		{cborCodec, []byte{0x9f, 0x01, 0x02, 0xff}, `[1,2]`},
+		{cborCodec, []byte{0x7f, 0x61, 'a', 0x61, 'b', 0xff}, `"ab"`},
+		{cborCodec, []byte{0x42, 'h', 'i'}, `"aGk="`},
+		{cborCodec, []byte{0xa1, 0x61}, ""},
+		{cborCodec, []byte{0xff}, ""},
+		{cborCodec, []byte{0x01, 0x02}, ""},
+		{cborCodec, []byte{0x9f, 0x01}, ""},
+		{cborCodec, bytes.Repeat([]byte{0x81}, maxDecodeDepth+2), ""},
+	}
+	for _, test := range tests {
+		got, err := test.codec.toJSON(test.data)
+		if test.json == "" {
+			if err == nil {
+				t.Errorf("%s : % x decoded to %s,want an error", test.codec.name, test.data, got)
+			}
+			continue
+		}
+		if err != nil || string(got) != test.json {
+			t.Errorf("%s : % x decoded to %s,%v,want %s", test.codec.name, test.data, got, err, test.json)
+		}
+	}
+}
+
+func TestCodecOf(t *testing.T) {
+	tests := []struct {
+		method, contentType, accept string
+		want                        *codec
+	}{
+		{"POST", "application/json", "", nil},
+		{"POST", "application/msgpack", "", msgpackCodec},
+		{"POST", "application/x-msgpack; charset=binary", "", msgpackCodec},
+		{"POST", "application/cbor", "", cborCodec},
+		{"GET", "", "application/cbor", cborCodec},
+		{"GET", "", "text/html, application/msgpack", msgpackCodec},
+		{"GET", "", "*/*", nil},
+	}
+	for _, test := range tests {
+		r, _ := http.NewRequest(test.method, "/token", nil)
+		if test.contentType != "" {
+			r.Header.Set("Content-Type", test.contentType)
+		}
+		if test.accept != "" {
+			r.Header.Set("Accept", test.accept)
+		}
+		if got := codecOf(r); got != test.want {
+			t.Errorf("%s Content-Type %q Accept %q : got %v,want %v", test.method, test.contentType, test.accept, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package rpc
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+// Compression configures the compression of http responses and websocket
+// messages,see WithCompression.
+type Compression struct {
+	// HTTP compresses responses with gzip or deflate as negotiated by
+	// Accept-Encoding.
+	HTTP bool
+	// WebSocket negotiates permessage-deflate with websocket clients.
+	WebSocket bool
+	// Threshold is the min bytes of a response or message to compress.
+	Threshold int
+	// Level is the flate level,from flate.HuffmanOnly to
+	// flate.BestCompression,flate.DefaultCompression is -1.
+	Level int
+}
+
+// CompressionStat counts the compressed responses or messages of an
+// encoding,Raw and Compressed are their bytes before and after compression.
+type CompressionStat struct {
+	Count      int64 `json:"count"`
+	Raw        int64 `json:"raw"`
+	Compressed int64 `json:"compressed"`
+}
+
+// WithCompression compresses http responses and websocket messages,
+// nothing is compressed by default.
+func WithCompression(c Compression) Option {
+	return func(s *Server) error {
+		if c.Level < flate.HuffmanOnly || c.Level > flate.BestCompression {
+			return fmt.Errorf("rpc: compression level should be %d to %d,got %d", flate.HuffmanOnly, flate.BestCompression, c.Level)
+		}
+		s.compression = &c
+		s.upgrader.EnableCompression = c.WebSocket
+		return nil
+	}
+}
+
+// CompressionStats returns the stats by encoding,"gzip" and "deflate" for
+// http responses and "websocket" for websocket messages,whose compressed
+// size includes the frame header.
+func (s *Server) CompressionStats() map[string]CompressionStat {
+	s.statsMutex.Lock()
+	defer s.statsMutex.Unlock()
+	stats := map[string]CompressionStat{}
+	for k, v := range s.compressionStats {
+		stats[k] = *v
+	}
+	return stats
+}
+
+func (s *Server) countCompression(encoding string, raw, compressed int64) {
+	s.statsMutex.Lock()
+	defer s.statsMutex.Unlock()
+	if s.compressionStats == nil {
+		s.compressionStats = map[string]*CompressionStat{}
+	}
+	stat := s.compressionStats[encoding]
+	if stat == nil {
+		stat = new(CompressionStat)
+		s.compressionStats[encoding] = stat
+	}
+	stat.Count++
+	stat.Raw += raw
+	stat.Compressed += compressed
+}
+
+// compress wraps h to compress the responses for clients which accept it,
+// websocket upgrades are passed through.
+func (s *Server) compress(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || isWS(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		cw := &compressWriter{ResponseWriter: w, s: s, encoding: encoding, status: http.StatusOK}
+		defer cw.close()
+		h.ServeHTTP(cw, r)
+	})
+}
+
+// acceptedEncoding returns "gzip" or "deflate" if header accepts them,
+// gzip first,or "".
+func acceptedEncoding(header string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, _ = strconv.ParseFloat(param[2:], 64)
+			}
+		}
+		accepted[name] = q > 0
+	}
+	for _, encoding := range []string{"gzip", "deflate"} {
+		if accepted[encoding] {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// compressWriter buffers a response until it reaches the threshold and
+// compresses it from there,smaller responses and streams flushed before
+// are sent as is.
+type compressWriter struct {
+	http.ResponseWriter
+	s        *Server
+	encoding string
+	status   int
+	buf      []byte
+
+	started    bool // the header is sent
+	compressor io.WriteCloser
+	counter    *countWriter
+	raw        int64
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.started {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status = status
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.compressor != nil {
+		w.raw += int64(len(p))
+		return w.compressor.Write(p)
+	}
+	if w.started {
+		return w.ResponseWriter.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.s.compression.Threshold {
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// start sends the header and the buffered body,compressed if compress is
+// true and the response is not encoded already.
+func (w *compressWriter) start(compress bool) (err error) {
+	w.started = true
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" || w.status == http.StatusNoContent || w.status == http.StatusNotModified {
+		compress = false
+	}
+	if compress {
+		w.counter = &countWriter{w: w.ResponseWriter}
+		level := w.s.compression.Level
+		if w.encoding == "gzip" {
+			w.compressor, err = gzip.NewWriterLevel(w.counter, level)
+		} else {
+			w.compressor, err = zlib.NewWriterLevel(w.counter, level)
+		}
+		if err != nil {
+			return
+		}
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return
+	}
+	_, err = w.Write(buf)
+	return
+}
+
+// Flush sends the response so far,a stream flushed before it reaches the
+// threshold is not compressed.
+func (w *compressWriter) Flush() {
+	if !w.started {
+		w.start(false)
+	}
+	if w.compressor != nil {
+		if f, ok := w.compressor.(interface{ Flush() error }); ok {
+			f.Flush()
+		}
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// close ends the response after the handler returned.
+func (w *compressWriter) close() {
+	if !w.started {
+		w.start(false)
+	}
+	if w.compressor != nil {
+		w.compressor.Close()
+		w.s.countCompression(w.encoding, w.raw, w.counter.n)
+	}
+}
+
+// countWriter counts the bytes written to w.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingListener counts the bytes written to its connections,which
+// measures the size of compressed websocket messages.
+type countingListener struct {
+	net.Listener
+}
+
+func (l countingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{Conn: c}, nil
+}
+
+type countingConn struct {
+	net.Conn
+	written int64 // atomic
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddInt64(&c.written, int64(n))
+	return n, err
+}
+
+func (c *countingConn) bytesWritten() int64 {
+	return atomic.LoadInt64(&c.written)
+}
+
+// wsCompression writes the messages of a websocket connection,compressing
+// those over the threshold when permessage-deflate was negotiated.
+type wsCompression struct {
+	s    *Server
+	conn *countingConn
+}
+
+// newWSCompression returns the compression of a connection upgraded from
+// r with conn,nil if the connection is not compressed.
+func (s *Server) newWSCompression(r *http.Request, conn net.Conn) *wsCompression {
+	if s.compression == nil || !s.compression.WebSocket || r == nil {
+		return nil
+	}
+	if !strings.Contains(strings.ToLower(r.Header.Get("Sec-WebSocket-Extensions")), "permessage-deflate") {
+		return nil
+	}
+	cc, _ := conn.(*countingConn)
+	return &wsCompression{s: s, conn: cc}
+}
+
+// write writes a message of type mt,compressed if it reaches the threshold.
+func (wc *wsCompression) write(c *websocket.Conn, mt int, data []byte) error {
+	compress := len(data) >= wc.s.compression.Threshold
+	c.EnableWriteCompression(compress)
+	if !compress || wc.conn == nil {
+		return c.WriteMessage(mt, data)
+	}
+	before := wc.conn.bytesWritten()
+	err := c.WriteMessage(mt, data)
+	wc.s.countCompression("websocket", int64(len(data)), wc.conn.bytesWritten()-before)
+	return err
+}
+
+// unwrapConn returns the connection accepted by the listener of c.
+func unwrapConn(c net.Conn) net.Conn {
+	if cc, ok := c.(*countingConn); ok {
+		return cc.Conn
+	}
+	return c
+}
@@ -0,0 +1,82 @@
+package rpc
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAcceptedEncoding(t *testing.T) {
+	tests := []struct {
+		header, encoding string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"deflate, gzip", "gzip"},
+		{"GZIP;q=0.5", "gzip"},
+		{"gzip;q=0, deflate", "deflate"},
+		{"gzip; q=0", ""},
+		{"br, identity", ""},
+		{"*", ""},
+	}
+	for _, test := range tests {
+		if got := acceptedEncoding(test.header); got != test.encoding {
+			t.Errorf("acceptedEncoding(%q) = %q,want %q", test.header, got, test.encoding)
+		}
+	}
+}
+
+func TestCompressHTTP(t *testing.T) {
+	s, err := New(WithService(&testService{}, "test"), WithREST(),
+		WithCompression(Compression{HTTP: true, Threshold: 100, Level: flate.BestSpeed}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := s.Handler()
+	tests := []struct {
+		acceptEncoding string
+		times          int
+		encoding       string
+	}{
+		{"gzip", 200, "gzip"},
+		{"deflate", 200, "deflate"},
+		{"gzip", 10, ""},
+		{"", 200, ""},
+	}
+	for _, test := range tests {
+		body := `{"text":"ab","times":` + strconv.Itoa(test.times) + `}`
+		r := httptest.NewRequest("POST", "/t/test/Echo", strings.NewReader(body))
+		r.Header.Set("Accept-Encoding", test.acceptEncoding)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if got := w.Header().Get("Content-Encoding"); got != test.encoding {
+			t.Errorf("%q %d : Content-Encoding %q,want %q", test.acceptEncoding, test.times, got, test.encoding)
+			continue
+		}
+		var reader io.Reader = w.Body
+		switch test.encoding {
+		case "gzip":
+			reader, err = gzip.NewReader(w.Body)
+		case "deflate":
+			reader, err = zlib.NewReader(w.Body)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(reader)
+		if want := `"` + strings.Repeat("ab", test.times) + `"` + "\n"; err != nil || string(got) != want {
+			t.Errorf("%q %d : body %q,%v,want %q", test.acceptEncoding, test.times, got, err, want)
+		}
+	}
+	stats := s.CompressionStats()
+	if stats["gzip"].Count != 1 || stats["deflate"].Count != 1 || stats["gzip"].Raw != 403 || stats["gzip"].Compressed >= 403 {
+		t.Errorf("stats %+v", stats)
+	}
+}
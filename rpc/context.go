@@ -0,0 +1,87 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	logger "github.com/snail007/mini-logger"
+)
+
+// ProgressMethod is the method of the notifications sent by Context.Progress,
+// params is {"id":<request id>,"progress":<data>}.
+const ProgressMethod = "rpc.progress"
+
+// Context is passed to service methods which take it as first argument:
+//   func (x *T) Method(ctx *rpc.Context, args *Args, reply *Reply) error
+// it is done when the client is gone.
+type Context struct {
+	context.Context
+	// Method is "service.Method".
+	Method string
+	// Id is the request id.
+	Id *json.RawMessage
+	// Request is the http request the call came in,the upgrade request
+	// for websocket,a GET of /<token> for raw tcp and nil for in-process calls.
+	Request *http.Request
+	// Peer is the identity of the caller on the unix socket,nil otherwise.
+	Peer *Peer
+	// Log logs with the method as field.
+	Log logger.MiniLogger
+
+	notify func(method string, params interface{}) error
+	caller *clientCaller
+	bus    *Bus
+}
+
+// Progress sends data to the client as a ProgressMethod notification,
+// it does nothing on transports which can not push,like plain http.
+func (c *Context) Progress(data interface{}) error {
+	if c.notify == nil {
+		return nil
+	}
+	return c.notify(ProgressMethod, map[string]interface{}{"id": c.Id, "progress": data})
+}
+
+// CanNotify returns true if the transport of the call can push notifications.
+func (c *Context) CanNotify() bool {
+	return c.notify != nil
+}
+
+// CallClient calls method of the client which sent the call and decodes
+// the result into reply,which may be nil to discard it. It fails after
+// timeout,DefaultClientCallTimeout if timeout <= 0,and returns
+// ErrNoClientCalls on transports which can't carry requests to the client.
+func (c *Context) CallClient(method string, params, reply interface{}, timeout time.Duration) error {
+	if c.caller == nil {
+		return ErrNoClientCalls
+	}
+	return c.caller.call(c.Context, method, params, reply, timeout)
+}
+
+// CanCallClient returns true if the transport of the call can carry
+// requests to the client.
+func (c *Context) CanCallClient() bool {
+	return c.caller != nil
+}
+
+// Confirm asks the client to confirm message with a ConfirmMethod call,
+// see CallClient.
+func (c *Context) Confirm(message string, timeout time.Duration) (ok bool, err error) {
+	err = c.CallClient(ConfirmMethod, map[string]interface{}{"message": message}, &ok, timeout)
+	return
+}
+
+// Publish publishes an event of topic with data on the event bus of the
+// server,see Server.Subscribe.
+func (c *Context) Publish(topic string, data interface{}) {
+	if c.bus != nil {
+		c.bus.Publish(topic, data)
+	}
+}
+
+// NewError returns a *RPCError,methods return it to set the error code and data.
+func NewError(code ErrorCode, message string, data interface{}) *RPCError {
+	return &RPCError{Code: code, Message: message, Data: data}
+}
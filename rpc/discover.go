@@ -0,0 +1,172 @@
+package rpc
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// DiscoverMethod is the built-in method which returns the description of
+// the registered services,see Server.Describe.
+const DiscoverMethod = "rpc.discover"
+
+// ServiceInfo describes a registered service.
+type ServiceInfo struct {
+	Name string `json:"name"`
+	// Proxy is true if the service is served by another process,
+	// the types of its methods are unknown.
+	Proxy   bool         `json:"proxy,omitempty"`
+	Methods []MethodInfo `json:"methods"`
+	// Type is the go type of the receiver,nil if the service is a proxy.
+	Type reflect.Type `json:"-"`
+}
+
+// MethodInfo describes a method of a service.
+type MethodInfo struct {
+	Name string `json:"name"`
+	// Names are the other names the method is called as,by name mapping
+	// and aliases.
+	Names  []string `json:"names,omitempty"`
+	Params *Schema  `json:"params,omitempty"`
+	Result *Schema  `json:"result,omitempty"`
+	// ParamsType and ResultType are the go types of params and result,
+	// nil if the method takes no params or the service is a proxy.
+	ParamsType reflect.Type `json:"-"`
+	ResultType reflect.Type `json:"-"`
+}
+
+// Schema describes the json value of a go type.
+type Schema struct {
+	// Type is a json type:"object","array","string","number","integer",
+	// "boolean" or "" for any.
+	Type string `json:"type,omitempty"`
+	// GoType is the name of the go type.
+	GoType     string             `json:"goType,omitempty"`
+	Properties map[string]*Schema `json:"properties,omitempty"`
+	Items      *Schema            `json:"items,omitempty"`
+}
+
+// Describe returns the description of the registered services sorted by name.
+func (s *Server) Describe() []ServiceInfo {
+	return s.services.describe()
+}
+
+// Alias makes alias call method of the service name,it fails if alias
+// collides with a method or another alias.
+func (s *Server) Alias(name, alias, method string) error {
+	return s.services.alias(name, alias, method)
+}
+
+func (m *serviceMap) describe() (infos []ServiceInfo) {
+	m.mutex.Lock()
+	services := []*service{}
+	for _, s := range m.services {
+		services = append(services, s)
+	}
+	m.mutex.Unlock()
+	sort.Slice(services, func(i, j int) bool { return services[i].name < services[j].name })
+	for _, s := range services {
+		info := ServiceInfo{Name: s.name, Proxy: s.proxy != nil}
+		if s.proxy == nil {
+			info.Type = s.rcvrType
+		}
+		methods := []string{}
+		if s.proxy != nil {
+			methods = s.proxy.Methods()
+		} else {
+			for name := range s.methods {
+				methods = append(methods, name)
+			}
+			sort.Strings(methods)
+		}
+		m.mutex.Lock()
+		for _, name := range methods {
+			mi := MethodInfo{Name: name}
+			if mapped := m.mapping.mapped(name); mapped != "" && mapped != name {
+				mi.Names = append(mi.Names, mapped)
+			}
+			mi.Names = append(mi.Names, s.aliases[name]...)
+			if sm := s.methods[name]; sm != nil {
+				if sm.argsType != nil {
+					mi.Params = schemaOf(sm.argsType, map[reflect.Type]bool{})
+					mi.ParamsType = sm.argsType
+				}
+				mi.Result = schemaOf(sm.replyType, map[reflect.Type]bool{})
+				mi.ResultType = sm.replyType
+			}
+			info.Methods = append(info.Methods, mi)
+		}
+		m.mutex.Unlock()
+		infos = append(infos, info)
+	}
+	return
+}
+
+// schemaOf returns the schema of t,seen stops recursive types.
+func schemaOf(t reflect.Type, seen map[reflect.Type]bool) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	schema := &Schema{GoType: t.String()}
+	switch t.Kind() {
+	case reflect.Bool:
+		schema.Type = "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		schema.Type = "integer"
+	case reflect.Float32, reflect.Float64:
+		schema.Type = "number"
+	case reflect.String:
+		schema.Type = "string"
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// []byte is base64 in json
+			schema.Type = "string"
+			break
+		}
+		schema.Type = "array"
+		schema.Items = schemaOf(t.Elem(), seen)
+	case reflect.Map:
+		schema.Type = "object"
+	case reflect.Struct:
+		schema.Type = "object"
+		if seen[t] {
+			break
+		}
+		seen[t] = true
+		schema.Properties = map[string]*Schema{}
+		addFields(schema, t, seen)
+		delete(seen, t)
+	}
+	return schema
+}
+
+// addFields adds the json fields of the struct t to schema.
+func addFields(schema *Schema, t reflect.Type, seen map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := f.Name
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		if i := strings.Index(tag, ","); i >= 0 {
+			tag = tag[:i]
+		}
+		if tag != "" {
+			name = tag
+		}
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && tag == "" && ft.Kind() == reflect.Struct {
+			addFields(schema, ft, seen)
+			continue
+		}
+		if !isExported(f.Name) {
+			continue
+		}
+		schema.Properties[name] = schemaOf(f.Type, seen)
+	}
+}
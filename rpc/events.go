@@ -0,0 +1,273 @@
+package rpc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// events
+//
+// the server has an event bus,services publish events on topics with
+// Context.Publish or Server.Publish and clients subscribe to topics:
+//   -> {"jsonrpc":"2.0","method":"rpc.subscribe","params":{"topics":["rpc.job"]},"id":1}
+//   <- {"jsonrpc":"2.0","result":"9f86d081884c7d65","error":null,"id":1}
+//   <- {"jsonrpc":"2.0","method":"rpc.event","params":{"subscription":"9f86d081884c7d65","event":{...}}}
+// subscriptions need a transport which can push,websocket or raw tcp,and
+// end with the connection. Http clients read the events from the sse
+// endpoint,see serveEvents. A topic "a.*" matches the topics under "a.",
+// "*" or no topics match all.
+
+const (
+	// SubscribeMethod subscribes the connection to topics and returns the
+	// subscription id,params is {"topics":[...]}.
+	SubscribeMethod = "rpc.subscribe"
+	// UnsubscribeMethod ends a subscription,params is {"id":"<subscription id>"}.
+	UnsubscribeMethod = "rpc.unsubscribe"
+	// EventMethod is the method of the notifications carrying events,
+	// params is {"subscription":"<id>","event":<Event>}.
+	EventMethod = "rpc.event"
+)
+
+// subscriptionBuffer is the number of events queued for a subscriber,
+// events are dropped for subscribers which fall behind.
+const subscriptionBuffer = 256
+
+// Event is a message published on the event bus.
+type Event struct {
+	// Seq increases with each event published on the bus.
+	Seq   uint64      `json:"seq"`
+	Topic string      `json:"topic"`
+	Time  time.Time   `json:"time"`
+	Data  interface{} `json:"data"`
+}
+
+// Bus delivers published events to the subscriptions of their topic.
+type Bus struct {
+	mutex sync.Mutex
+	seq   uint64
+	subs  map[string]*Subscription
+}
+
+// Subscription receives the events of its topics on C until it is closed.
+type Subscription struct {
+	ID string
+	C  <-chan Event
+
+	c       chan Event
+	topics  []string
+	bus     *Bus
+	dropped uint64
+	closed  bool
+}
+
+// NewBus returns an empty Bus.
+func NewBus() *Bus {
+	return &Bus{subs: map[string]*Subscription{}}
+}
+
+// Publish sends an event of topic with data to the subscriptions of topic.
+func (b *Bus) Publish(topic string, data interface{}) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.seq++
+	e := Event{Seq: b.seq, Topic: topic, Time: time.Now(), Data: data}
+	for _, s := range b.subs {
+		if !matchTopic(s.topics, topic) {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			s.dropped++
+		}
+	}
+}
+
+// Subscribe returns a subscription of topics,all topics if none.
+func (b *Bus) Subscribe(topics ...string) *Subscription {
+	c := make(chan Event, subscriptionBuffer)
+	s := &Subscription{ID: newID(), C: c, c: c, topics: topics, bus: b}
+	b.mutex.Lock()
+	b.subs[s.ID] = s
+	b.mutex.Unlock()
+	return s
+}
+
+// Close ends the subscription and closes C.
+func (s *Subscription) Close() {
+	s.bus.unsubscribe(s.ID)
+}
+
+// Dropped returns the number of events dropped because C was full.
+func (s *Subscription) Dropped() uint64 {
+	s.bus.mutex.Lock()
+	defer s.bus.mutex.Unlock()
+	return s.dropped
+}
+
+func (b *Bus) unsubscribe(id string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	s, ok := b.subs[id]
+	if !ok {
+		return false
+	}
+	delete(b.subs, id)
+	if !s.closed {
+		s.closed = true
+		close(s.c)
+	}
+	return true
+}
+
+// matchTopic returns true if topic is one of topics,or under a "prefix.*"
+// of them,or topics is empty.
+func matchTopic(topics []string, topic string) bool {
+	if len(topics) == 0 {
+		return true
+	}
+	for _, t := range topics {
+		if t == "*" || t == topic {
+			return true
+		}
+		if strings.HasSuffix(t, ".*") && strings.HasPrefix(topic, t[:len(t)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// newID returns a random hex id,unguessable so that ids can't be used to
+// reach the subscriptions and jobs of other clients.
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Publish publishes an event of topic with data on the event bus.
+func (s *Server) Publish(topic string, data interface{}) {
+	s.bus.Publish(topic, data)
+}
+
+// Subscribe returns a subscription of topics on the event bus.
+func (s *Server) Subscribe(topics ...string) *Subscription {
+	return s.bus.Subscribe(topics...)
+}
+
+// subscribe is SubscribeMethod,the events are pushed until the connection
+// of c is gone or the subscription is ended.
+func (s *Server) subscribe(c *Call) (interface{}, error) {
+	if c.notify == nil {
+		return nil, &RPCError{Code: E_INVALID_REQ, Message: "rpc: subscriptions need websocket or raw tcp,use the sse endpoint over http"}
+	}
+	var params struct {
+		Topics []string `json:"topics"`
+	}
+	if c.Params != nil {
+		if err := json.Unmarshal(*c.Params, &params); err != nil {
+			return nil, &RPCError{Code: E_BAD_PARAMS, Message: err.Error()}
+		}
+	}
+	sub := s.bus.Subscribe(params.Topics...)
+	notify, done := c.notify, c.Context.Done()
+	go func() {
+		for {
+			select {
+			case e, ok := <-sub.C:
+				if !ok {
+					return
+				}
+				if notify(EventMethod, map[string]interface{}{"subscription": sub.ID, "event": e}) != nil {
+					sub.Close()
+					return
+				}
+			case <-done:
+				sub.Close()
+				return
+			}
+		}
+	}()
+	return sub.ID, nil
+}
+
+// unsubscribe is UnsubscribeMethod.
+func (s *Server) unsubscribe(c *Call) (interface{}, error) {
+	var params struct {
+		Id string `json:"id"`
+	}
+	if c.Params == nil || json.Unmarshal(*c.Params, &params) != nil {
+		return nil, &RPCError{Code: E_BAD_PARAMS, Message: "bad params"}
+	}
+	if !s.bus.unsubscribe(params.Id) {
+		return nil, &RPCError{Code: E_BAD_PARAMS, Message: "rpc: no subscription " + params.Id}
+	}
+	return true, nil
+}
+
+// sseKeepalive is the interval of the comments which keep idle event
+// streams open through proxies
+const sseKeepalive = 15 * time.Second
+
+// serveEvents streams the events of the topics in the query as server-sent
+// events until the client is gone,all topics if none:
+//   GET /<token>/rpc/events?topics=rpc.job,rpc.progress
+// each event is sent with its Seq as id and its Topic as event name:
+//   id: 42
+//   event: rpc.job
+//   data: {"seq":42,"topic":"rpc.job","time":"...","data":{...}}
+func (s *Server) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeREST(w, r, http.StatusInternalServerError, &RPCError{Code: E_INTERNAL, Message: "rpc: streaming unsupported"})
+		return
+	}
+	topics := []string{}
+	for _, t := range r.URL.Query()["topics"] {
+		for _, topic := range strings.Split(t, ",") {
+			if topic = strings.TrimSpace(topic); topic != "" {
+				topics = append(topics, topic)
+			}
+		}
+	}
+	sub := s.bus.Subscribe(topics...)
+	defer sub.Close()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	ticker := time.NewTicker(sseKeepalive)
+	defer ticker.Stop()
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				s.log.Warnf("event %s : %s", e.Topic, err)
+				continue
+			}
+			if _, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Seq, e.Topic, data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-s.ctx.Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,92 @@
+package rpc
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchTopic(t *testing.T) {
+	tests := []struct {
+		topics []string
+		topic  string
+		match  bool
+	}{
+		{nil, "a", true},
+		{[]string{"*"}, "a.b", true},
+		{[]string{"a"}, "a", true},
+		{[]string{"a"}, "a.b", false},
+		{[]string{"a.*"}, "a.b", true},
+		{[]string{"a.*"}, "a.b.c", true},
+		{[]string{"a.*"}, "a", false},
+		{[]string{"a.*"}, "ab.c", false},
+		{[]string{"b", "a.*"}, "a.x", true},
+		{[]string{"b", "c"}, "a", false},
+	}
+	for _, test := range tests {
+		if got := matchTopic(test.topics, test.topic); got != test.match {
+			t.Errorf("matchTopic(%q,%q) = %v,want %v", test.topics, test.topic, got, test.match)
+		}
+	}
+}
+
+func TestBus(t *testing.T) {
+	b := NewBus()
+	sub := b.Subscribe("a.*")
+	b.Publish("b", 1)
+	b.Publish("a.x", 2)
+	e := <-sub.C
+	if e.Topic != "a.x" || e.Data != 2 || e.Seq != 2 {
+		t.Errorf("got %+v,want the second event", e)
+	}
+	for i := 0; i < subscriptionBuffer+3; i++ {
+		b.Publish("a.y", i)
+	}
+	if dropped := sub.Dropped(); dropped != 3 {
+		t.Errorf("dropped %d,want 3", dropped)
+	}
+	sub.Close()
+	n := 0
+	for range sub.C {
+		n++
+	}
+	if n != subscriptionBuffer {
+		t.Errorf("read %d events after Close,want %d", n, subscriptionBuffer)
+	}
+	// a second Close is a no-op
+	sub.Close()
+}
+
+func TestServeEvents(t *testing.T) {
+	s, ts := newTestServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	r, _ := http.NewRequest("GET", ts.URL+"/t/rpc/events?topics=b,a.*", nil)
+	resp, err := http.DefaultClient.Do(r.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	// the headers are flushed once the subscription is made
+	s.Publish("c", "skipped")
+	s.Publish("a.x", map[string]int{"n": 1})
+	reader := bufio.NewReader(resp.Body)
+	lines := []string{}
+	for len(lines) < 3 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+	if lines[0] != "id: 2" || lines[1] != "event: a.x" || !strings.HasPrefix(lines[2], `data: {"seq":2,"topic":"a.x",`) ||
+		!strings.HasSuffix(lines[2], `"data":{"n":1}}`) {
+		t.Errorf("got %q", lines)
+	}
+}
@@ -0,0 +1,208 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// async calls
+//
+// AsyncMethod runs a call in the background and returns its job at once:
+//   -> {"jsonrpc":"2.0","method":"rpc.async","params":{"method":"git.Publish","params":{...}},"id":1}
+//   <- {"jsonrpc":"2.0","result":{"id":"4e07408562bedb8b","method":"git.Publish","status":"running",...},"error":null,"id":1}
+// the progress of the call is published on ProgressMethod with the job id
+// as id,the finished job on JobTopic. Http clients wait for the result on
+// the long-poll endpoint,see serveJob.
+
+const (
+	// AsyncMethod calls params.method with params.params in the background
+	// and returns the Job.
+	AsyncMethod = "rpc.async"
+	// JobTopic is the topic of the events of finished jobs,the data is the Job.
+	JobTopic = "rpc.job"
+)
+
+const (
+	// jobRetention is how long finished jobs are kept for waiters
+	jobRetention = 10 * time.Minute
+	// maxFinishedJobs bounds the finished jobs kept
+	maxFinishedJobs = 1000
+)
+
+// job status
+const (
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// Job is a call running in the background.
+type Job struct {
+	ID      string      `json:"id"`
+	Method  string      `json:"method"`
+	Status  string      `json:"status"`
+	Result  interface{} `json:"result,omitempty"`
+	Error   *RPCError   `json:"error,omitempty"`
+	Started time.Time   `json:"started"`
+	Ended   *time.Time  `json:"ended,omitempty"`
+
+	done chan struct{}
+}
+
+// jobs are the jobs of a server.
+type jobs struct {
+	mutex    sync.Mutex
+	jobs     map[string]*Job
+	finished []string // ids of finished jobs,oldest first
+}
+
+func (js *jobs) add(j *Job) {
+	js.mutex.Lock()
+	defer js.mutex.Unlock()
+	if js.jobs == nil {
+		js.jobs = map[string]*Job{}
+	}
+	js.jobs[j.ID] = j
+}
+
+// finish sets the outcome of j,drops the expired finished jobs and returns
+// a copy of j,which may be dropped already.
+func (js *jobs) finish(j *Job, result interface{}, err *RPCError) *Job {
+	js.mutex.Lock()
+	defer js.mutex.Unlock()
+	now := time.Now()
+	j.Ended = &now
+	j.Result, j.Error, j.Status = result, err, JobDone
+	if err != nil {
+		j.Status = JobFailed
+	}
+	close(j.done)
+	js.finished = append(js.finished, j.ID)
+	for len(js.finished) > 0 {
+		first := js.jobs[js.finished[0]]
+		if len(js.finished) <= maxFinishedJobs && now.Sub(*first.Ended) < jobRetention {
+			break
+		}
+		delete(js.jobs, first.ID)
+		js.finished = js.finished[1:]
+	}
+	c := *j
+	return &c
+}
+
+// get returns a copy of the job id,nil if unknown.
+func (js *jobs) get(id string) *Job {
+	js.mutex.Lock()
+	defer js.mutex.Unlock()
+	j, ok := js.jobs[id]
+	if !ok {
+		return nil
+	}
+	c := *j
+	return &c
+}
+
+// wait returns a copy of the job id once it finished or ctx is done,nil if
+// unknown. The job is returned even if it expires meanwhile.
+func (js *jobs) wait(ctx context.Context, id string) *Job {
+	js.mutex.Lock()
+	j := js.jobs[id]
+	js.mutex.Unlock()
+	if j == nil {
+		return nil
+	}
+	select {
+	case <-j.done:
+	case <-ctx.Done():
+	}
+	js.mutex.Lock()
+	defer js.mutex.Unlock()
+	c := *j
+	return &c
+}
+
+// async is AsyncMethod.
+func (s *Server) async(c *Call) (interface{}, error) {
+	var params struct {
+		Method string           `json:"method"`
+		Params *json.RawMessage `json:"params"`
+	}
+	if c.Params == nil || json.Unmarshal(*c.Params, &params) != nil || params.Method == "" {
+		return nil, &RPCError{Code: E_BAD_PARAMS, Message: "bad params,expected {\"method\":...,\"params\":...}"}
+	}
+	if strings.HasPrefix(params.Method, "rpc.") {
+		return nil, &RPCError{Code: E_BAD_PARAMS, Message: "rpc: " + params.Method + " can't be called async"}
+	}
+	j := &Job{ID: newID(), Method: params.Method, Status: JobRunning, Started: time.Now(), done: make(chan struct{})}
+	id := json.RawMessage(`"` + j.ID + `"`)
+	call := &Call{
+		Method:  params.Method,
+		Params:  params.Params,
+		Id:      &id,
+		Request: c.Request,
+		Context: s.ctx,
+		notify: func(method string, params interface{}) error {
+			s.bus.Publish(method, params)
+			return nil
+		},
+	}
+	s.jobs.add(j)
+	started := *j
+	go func() {
+		result, err := s.invoke(call)
+		var rpcErr *RPCError
+		if err != nil {
+			var ok bool
+			if rpcErr, ok = err.(*RPCError); !ok {
+				rpcErr = &RPCError{Code: E_INTERNAL, Message: err.Error()}
+			}
+		}
+		s.bus.Publish(JobTopic, s.jobs.finish(j, result, rpcErr))
+	}()
+	return &started, nil
+}
+
+const (
+	// defaultWait and maxWait are the default and max timeout of the
+	// long-poll endpoint
+	defaultWait = 30 * time.Second
+	maxWait     = 5 * time.Minute
+)
+
+// serveJob waits for the job id to finish for the timeout seconds in the
+// query and returns it:
+//   GET /<token>/rpc/jobs/<id>?timeout=30
+// the status is 200 if the job finished,202 if it is still running and
+// 404 if it is unknown or expired.
+func (s *Server) serveJob(w http.ResponseWriter, r *http.Request, id string) {
+	timeout := defaultWait
+	if t := r.URL.Query().Get("timeout"); t != "" {
+		seconds, err := strconv.ParseFloat(t, 64)
+		if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) || seconds < 0 {
+			writeREST(w, r, http.StatusBadRequest, &RPCError{Code: E_BAD_PARAMS, Message: "timeout should be seconds"})
+			return
+		}
+		// clamped before the conversion,which overflows for large values
+		timeout = time.Duration(math.Min(seconds, maxWait.Seconds()) * float64(time.Second))
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	stop := context.AfterFunc(s.ctx, cancel)
+	defer stop()
+	j := s.jobs.wait(ctx, id)
+	if j == nil {
+		writeREST(w, r, http.StatusNotFound, &RPCError{Code: E_BAD_PARAMS, Message: "rpc: no job " + id})
+		return
+	}
+	status := http.StatusOK
+	if j.Status == JobRunning {
+		status = http.StatusAccepted
+	}
+	writeREST(w, r, status, j)
+}
@@ -0,0 +1,117 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newJob(id string) *Job {
+	return &Job{ID: id, Status: JobRunning, Started: time.Now(), done: make(chan struct{})}
+}
+
+func TestJobsExpiry(t *testing.T) {
+	var js jobs
+	for i := 0; i < maxFinishedJobs+2; i++ {
+		j := newJob(fmt.Sprint(i))
+		js.add(j)
+		js.finish(j, i, nil)
+	}
+	if js.get("0") != nil || js.get("1") != nil {
+		t.Error("the oldest jobs are kept over maxFinishedJobs")
+	}
+	if j := js.get("2"); j == nil || j.Status != JobDone || j.Result != 2 {
+		t.Errorf("job 2 = %+v", j)
+	}
+	// jobs finished jobRetention ago expire with the next finished job
+	old := time.Now().Add(-jobRetention)
+	for _, j := range js.jobs {
+		j.Ended = &old
+	}
+	j := newJob("new")
+	js.add(j)
+	js.finish(j, nil, &RPCError{Code: E_INTERNAL, Message: "x"})
+	if len(js.jobs) != 1 || len(js.finished) != 1 {
+		t.Errorf("%d jobs %d finished kept,want 1", len(js.jobs), len(js.finished))
+	}
+	if j := js.get("new"); j == nil || j.Status != JobFailed {
+		t.Errorf("job new = %+v", j)
+	}
+}
+
+func TestJobsWait(t *testing.T) {
+	var js jobs
+	if js.wait(context.Background(), "none") != nil {
+		t.Error("waited an unknown job")
+	}
+	j := newJob("a")
+	js.add(j)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if got := js.wait(ctx, "a"); got == nil || got.Status != JobRunning {
+		t.Errorf("wait until the timeout = %+v", got)
+	}
+	done := make(chan *Job)
+	go func() {
+		done <- js.wait(context.Background(), "a")
+	}()
+	time.Sleep(10 * time.Millisecond)
+	js.finish(j, "ok", nil)
+	// expire the job before the waiter reads it
+	js.mutex.Lock()
+	delete(js.jobs, "a")
+	js.mutex.Unlock()
+	if got := <-done; got == nil || got.Status != JobDone || got.Result != "ok" {
+		t.Errorf("wait = %+v", got)
+	}
+}
+
+func TestServeJob(t *testing.T) {
+	s, ts := newTestServer(t)
+	start := func(ms int) string {
+		response := s.Process([]byte(fmt.Sprintf(`{"jsonrpc":"2.0","method":"rpc.async","params":{"method":"test.Sleep","params":%d},"id":1}`, ms)), nil)
+		var r struct {
+			Result Job
+		}
+		if err := json.Unmarshal([]byte(response), &r); err != nil || r.Result.Status != JobRunning {
+			t.Fatalf("async : %s", response)
+		}
+		return r.Result.ID
+	}
+	slow, fast := start(500), start(10)
+	tests := []struct {
+		id, timeout string
+		status      int
+		response    string
+	}{
+		{fast, "", 200, `"status":"done","result":10`},
+		{slow, "0", 202, `"status":"running"`},
+		{slow, "0.01", 202, `"status":"running"`},
+		{"none", "0", 404, `"rpc: no job none"`},
+		{slow, "-1", 400, `"timeout should be seconds"`},
+		{slow, "NaN", 400, `"timeout should be seconds"`},
+		{slow, "Inf", 400, `"timeout should be seconds"`},
+		{slow, "x", 400, `"timeout should be seconds"`},
+		{"none", "1e300", 404, `"rpc: no job none"`},
+	}
+	for _, test := range tests {
+		url := ts.URL + "/t/rpc/jobs/" + test.id
+		if test.timeout != "" {
+			url += "?timeout=" + test.timeout
+		}
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != test.status || !strings.Contains(string(body), test.response) {
+			t.Errorf("timeout %q : got %d %s,want %d %s", test.timeout, resp.StatusCode, body, test.status, test.response)
+		}
+	}
+}
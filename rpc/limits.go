@@ -0,0 +1,58 @@
+package rpc
+
+import (
+	"errors"
+	"time"
+)
+
+// Limits are the limits of the server which may be changed while it
+// serves,see SetLimits. Zero disables each of them.
+type Limits struct {
+	// ReadLimit is the max bytes of a request body,websocket message or raw
+	// tcp line,see WithReadLimit.
+	ReadLimit int64
+	// Ping,ReadTimeout and WriteTimeout are the keepalive of websocket
+	// clients,see WithKeepalive.
+	Ping         time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// SessionTTL and SessionBuffer are the resumable sessions of websocket
+	// clients,see WithSessions.
+	SessionTTL    time.Duration
+	SessionBuffer int
+}
+
+func (l Limits) validate() error {
+	if l.ReadTimeout > 0 && (l.Ping <= 0 || l.Ping >= l.ReadTimeout) {
+		return errors.New("rpc: the ping interval should be shorter than the read timeout")
+	}
+	return nil
+}
+
+// Limits returns the limits in use.
+func (s *Server) Limits() Limits {
+	l, _ := s.limits.Load().(Limits)
+	return l
+}
+
+// SetLimits replaces the limits. Request bodies and the sessions in use
+// get them at once,connections get the read limit and keepalive when they
+// are accepted.
+func (s *Server) SetLimits(l Limits) error {
+	if err := l.validate(); err != nil {
+		return err
+	}
+	s.limits.Store(l)
+	return nil
+}
+
+// setLimits changes the limits with fn,for the options.
+func (s *Server) setLimits(fn func(l *Limits)) error {
+	l := s.Limits()
+	fn(&l)
+	if err := l.validate(); err != nil {
+		return err
+	}
+	s.limits.Store(l)
+	return nil
+}
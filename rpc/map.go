@@ -0,0 +1,446 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The snail007 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	logger "github.com/snail007/mini-logger"
+)
+
+var (
+	// Precompute the reflect.Type of error, http.Request and *Context
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfRequest = reflect.TypeOf((*http.Request)(nil)).Elem()
+	typeOfContext = reflect.TypeOf((*Context)(nil))
+)
+
+// ----------------------------------------------------------------------------
+// lifecycle
+// ----------------------------------------------------------------------------
+
+// Initializer is implemented by services which need setup before serving,
+// settings is the plugin config section. An error aborts startup. Init is
+// called again with the new settings when they change on reload,while the
+// service serves,it should keep the previous settings on error.
+type Initializer interface {
+	Init(settings map[string]interface{}, log logger.MiniLogger) error
+}
+
+// HealthChecker is implemented by services which can report readiness,
+// a nil error means healthy.
+type HealthChecker interface {
+	Health() error
+}
+
+// Closer is implemented by services which release resources on shutdown,
+// Close should return before ctx is done.
+type Closer interface {
+	Close(ctx context.Context) error
+}
+
+// isLifecycleMethod returns true if name is a lifecycle method of rcvr.
+func isLifecycleMethod(rcvr interface{}, name string) (ok bool) {
+	switch name {
+	case "Init":
+		_, ok = rcvr.(Initializer)
+	case "Health":
+		_, ok = rcvr.(HealthChecker)
+	case "Close":
+		_, ok = rcvr.(Closer)
+	}
+	return
+}
+
+// ----------------------------------------------------------------------------
+// service
+// ----------------------------------------------------------------------------
+
+type service struct {
+	name     string                    // name of service
+	rcvr     reflect.Value             // receiver of methods for the service
+	rcvrType reflect.Type              // type of the receiver
+	methods  map[string]*serviceMethod // registered methods
+	proxy    Proxy                     // set if the service is served by another process
+	names    map[string]string         // method by mapped name and alias
+	aliases  map[string][]string       // aliases by method
+}
+
+// Proxy is implemented by services served by another process,
+// their methods are not known by reflection.
+type Proxy interface {
+	// Call calls method of the service with the raw json params.
+	Call(method string, params *json.RawMessage) (result *json.RawMessage, err error)
+	// HasMethod returns true if the service currently has method.
+	HasMethod(method string) bool
+	// Methods returns the current methods of the service.
+	Methods() []string
+}
+
+type serviceMethod struct {
+	method     reflect.Method // receiver method
+	hasContext bool           // first argument is *Context
+	argsType   reflect.Type   // type of the request argument
+	replyType  reflect.Type   // type of the response argument
+}
+
+// ----------------------------------------------------------------------------
+// serviceMap
+// ----------------------------------------------------------------------------
+
+// serviceMap is a registry for services.
+//
+// service names are dotted namespaces like "git" or "git.repo",a name
+// ending with a version like "git.v2" is that version of "git".
+type serviceMap struct {
+	mutex           sync.Mutex
+	services        map[string]*service
+	versions        map[string][]string // registered versions by unversioned name
+	defaultVersions map[string]string   // configured default version by unversioned name
+	mapping         NameMapping         // how call names map to method names
+}
+
+// versionPattern matches api versions like "v1"
+var versionPattern = regexp.MustCompile(`^v[0-9]+$`)
+
+// IsVersion returns true if s is an api version like "v1".
+func IsVersion(s string) bool {
+	return versionPattern.MatchString(s)
+}
+
+// setDefaultVersion sets the version of the service name which
+// unversioned calls resolve to.
+func (m *serviceMap) setDefaultVersion(name, version string) error {
+	if !IsVersion(version) {
+		return fmt.Errorf("rpc: version ill-formed: %q", version)
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.ensure()
+	m.defaultVersions[name] = version
+	return nil
+}
+
+// ensure makes the maps. Must be called with the mutex held.
+func (m *serviceMap) ensure() {
+	if m.services == nil {
+		m.services = make(map[string]*service)
+		m.versions = make(map[string][]string)
+		m.defaultVersions = make(map[string]string)
+	}
+}
+
+// lookup returns the service name,or the default version of it if name
+// is not registered unversioned. Must be called with the mutex held.
+func (m *serviceMap) lookup(name string) *service {
+	if s := m.services[name]; s != nil {
+		return s
+	}
+	versions := m.versions[name]
+	if len(versions) == 0 {
+		return nil
+	}
+	// the first registered version is the default if none is configured
+	version := versions[0]
+	if v, ok := m.defaultVersions[name]; ok {
+		version = v
+	}
+	return m.services[name+"."+version]
+}
+
+// register adds a new service using reflection to extract its methods.
+func (m *serviceMap) register(rcvr interface{}, name string) error {
+	// Setup service.
+	s := &service{
+		name:     name,
+		rcvr:     reflect.ValueOf(rcvr),
+		rcvrType: reflect.TypeOf(rcvr),
+		methods:  make(map[string]*serviceMethod),
+	}
+	if name == "" {
+		s.name = reflect.Indirect(s.rcvr).Type().Name()
+		if !isExported(s.name) {
+			return fmt.Errorf("rpc: type %q is not exported", s.name)
+		}
+	}
+	if s.name == "" {
+		return fmt.Errorf("rpc: no service name for type %q",
+			s.rcvrType.String())
+	}
+	// Setup methods.
+	for i := 0; i < s.rcvrType.NumMethod(); i++ {
+		method := s.rcvrType.Method(i)
+		// Method must be exported.
+		if method.PkgPath != "" {
+			continue
+		}
+		// Lifecycle methods are not rpc methods.
+		if isLifecycleMethod(rcvr, method.Name) {
+			continue
+		}
+		if sm, _ := methodShape(method); sm != nil {
+			s.methods[method.Name] = sm
+		}
+	}
+	if len(s.methods) == 0 {
+		return fmt.Errorf("rpc: %q has no exported methods of suitable type",
+			s.name)
+	}
+	return m.add(s)
+}
+
+// add adds s to the map,a name ending with a version like "git.v2"
+// registers that version of the service "git".
+func (m *serviceMap) add(s *service) error {
+	for _, part := range strings.Split(s.name, ".") {
+		if part == "" {
+			return fmt.Errorf("rpc: service name ill-formed: %q", s.name)
+		}
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.ensure()
+	if _, ok := m.services[s.name]; ok {
+		return fmt.Errorf("rpc: service already defined: %q", s.name)
+	}
+	s.names = make(map[string]string)
+	s.aliases = make(map[string][]string)
+	methods := []string{}
+	for method := range s.methods {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	for _, method := range methods {
+		if err := m.addName(s, m.mapping.mapped(method), method); err != nil {
+			return err
+		}
+	}
+	m.services[s.name] = s
+	if i := strings.LastIndex(s.name, "."); i > 0 && IsVersion(s.name[i+1:]) {
+		base := s.name[:i]
+		m.versions[base] = append(m.versions[base], s.name[i+1:])
+	}
+	return nil
+}
+
+// registerProxy adds a new service served by p.
+func (m *serviceMap) registerProxy(p Proxy, name string) error {
+	if name == "" {
+		return fmt.Errorf("rpc: no service name for proxy")
+	}
+	s := &service{
+		name:     name,
+		rcvr:     reflect.ValueOf(p),
+		rcvrType: reflect.TypeOf(p),
+		proxy:    p,
+	}
+	return m.add(s)
+}
+
+// methodShape returns the serviceMethod of method,or the reason why it is
+// not a rpc method. Methods look like:
+//   func (x *T) Method([ctx *Context,] [args *Args,] reply *Reply) error
+func methodShape(method reflect.Method) (sm *serviceMethod, reason string) {
+	mtype := method.Type
+	sm = &serviceMethod{method: method}
+	in := 1
+	if mtype.NumIn() > in && mtype.In(in) == typeOfContext {
+		sm.hasContext = true
+		in++
+	}
+	// Method needs ins: *args, *reply or *reply after the receiver and context.
+	switch mtype.NumIn() - in {
+	case 2:
+		args := mtype.In(in)
+		if args.Kind() != reflect.Ptr || !isExportedOrBuiltin(args) {
+			return nil, fmt.Sprintf("args type %s should be a pointer to an exported or builtin type", args)
+		}
+		sm.argsType = args.Elem()
+		in++
+	case 1:
+	default:
+		return nil, "should take (*Args, *Reply) or (*Reply),optionally after *rpc.Context"
+	}
+	// Reply must be a pointer and must be exported.
+	reply := mtype.In(in)
+	if reply.Kind() != reflect.Ptr || !isExportedOrBuiltin(reply) {
+		return nil, fmt.Sprintf("reply type %s should be a pointer to an exported or builtin type", reply)
+	}
+	sm.replyType = reply.Elem()
+	// Method needs one out: error.
+	if mtype.NumOut() != 1 || mtype.Out(0) != typeOfError {
+		return nil, "should return only error"
+	}
+	return sm, ""
+}
+
+// Check returns an error listing the exported methods of rcvr which are
+// not rpc methods and why,register skips them silently.
+func Check(rcvr interface{}) error {
+	t := reflect.TypeOf(rcvr)
+	if t == nil {
+		return fmt.Errorf("rpc: nil service")
+	}
+	problems := []string{}
+	methods := 0
+	for i := 0; i < t.NumMethod(); i++ {
+		method := t.Method(i)
+		if method.PkgPath != "" || isLifecycleMethod(rcvr, method.Name) {
+			continue
+		}
+		if _, reason := methodShape(method); reason != "" {
+			problems = append(problems, fmt.Sprintf("method %s %s", method.Name, reason))
+			continue
+		}
+		methods++
+	}
+	if methods == 0 {
+		problems = append(problems, "no exported methods of suitable type")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("rpc: %s : %s", t, strings.Join(problems, " ; "))
+	}
+	return nil
+}
+
+// get returns a registered service given a method name.
+//
+// The method name uses a dotted notation as in "Service.Method",the
+// service is the longest registered prefix which has the rest as method,
+// so "git.repo.Status" is "git.repo" + "Status" or "git" + "repo.Status".
+func (m *serviceMap) get(method string) (*service, *serviceMethod, error) {
+	parts := strings.Split(method, ".")
+	if len(parts) < 2 {
+		err := fmt.Errorf("rpc: service/method request ill-formed: %q", method)
+		return nil, nil, err
+	}
+	found := false
+	for i := len(parts) - 1; i > 0; i-- {
+		name, rest := strings.Join(parts[:i], "."), strings.Join(parts[i:], ".")
+		m.mutex.Lock()
+		service := m.lookup(name)
+		if service != nil {
+			rest = m.resolve(service, rest)
+		}
+		m.mutex.Unlock()
+		if service == nil {
+			continue
+		}
+		found = true
+		if service.proxy != nil {
+			if rest = m.resolveProxy(service.proxy, rest); service.proxy.HasMethod(rest) {
+				return service, &serviceMethod{method: reflect.Method{Name: rest}}, nil
+			}
+			continue
+		}
+		if serviceMethod := service.methods[rest]; serviceMethod != nil {
+			return service, serviceMethod, nil
+		}
+	}
+	if !found {
+		err := fmt.Errorf("rpc: can't find service %q", method)
+		return nil, nil, err
+	}
+	err := fmt.Errorf("rpc: can't find method %q", method)
+	return nil, nil, err
+}
+
+// init calls Init of the service name if it is an Initializer.
+func (m *serviceMap) init(name string, settings map[string]interface{}, log logger.MiniLogger) error {
+	m.mutex.Lock()
+	s := m.services[name]
+	m.mutex.Unlock()
+	if s == nil {
+		return fmt.Errorf("rpc: can't find service %q", name)
+	}
+	if i, ok := s.rcvr.Interface().(Initializer); ok {
+		return i.Init(settings, log)
+	}
+	return nil
+}
+
+// health returns the health of each service,services which are not a
+// HealthChecker are healthy. The checks run without the lock,a slow one
+// does not hold up the dispatch of calls.
+func (m *serviceMap) health() (healthy bool, status map[string]string) {
+	healthy = true
+	status = map[string]string{}
+	m.mutex.Lock()
+	services := make(map[string]*service, len(m.services))
+	for name, s := range m.services {
+		services[name] = s
+	}
+	m.mutex.Unlock()
+	for name, s := range services {
+		status[name] = "ok"
+		if h, ok := s.rcvr.Interface().(HealthChecker); ok {
+			if err := h.Health(); err != nil {
+				status[name] = err.Error()
+				healthy = false
+			}
+		}
+	}
+	return
+}
+
+// close calls Close of each Closer service in reverse name order,a service
+// which does not return before ctx is done is abandoned.
+func (m *serviceMap) close(ctx context.Context, log logger.MiniLogger) {
+	m.mutex.Lock()
+	names := []string{}
+	for name := range m.services {
+		names = append(names, name)
+	}
+	m.mutex.Unlock()
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	for _, name := range names {
+		m.mutex.Lock()
+		c, ok := m.services[name].rcvr.Interface().(Closer)
+		m.mutex.Unlock()
+		if !ok {
+			continue
+		}
+		done := make(chan error, 1)
+		go func() {
+			done <- c.Close(ctx)
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				log.Warnf("close service %q fail : %s", name, err)
+			}
+		case <-ctx.Done():
+			log.Warnf("close service %q : %s", name, ctx.Err())
+			return
+		}
+	}
+}
+
+// isExported returns true of a string is an exported (upper case) name.
+func isExported(name string) bool {
+	rune, _ := utf8.DecodeRuneInString(name)
+	return unicode.IsUpper(rune)
+}
+
+// isExportedOrBuiltin returns true if a type is exported or a builtin.
+func isExportedOrBuiltin(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	// PkgPath will be non-empty even for an exported type,
+	// so we need to check the type name as well.
+	return isExported(t.Name()) || t.PkgPath() == ""
+}
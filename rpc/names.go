@@ -0,0 +1,148 @@
+package rpc
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// NameMapping is how method names in calls are mapped to Go method names,
+// the Go method name and aliases always match.
+type NameMapping int
+
+const (
+	// ExactNames matches the Go method name only,"system.Time".
+	ExactNames NameMapping = iota
+	// CaseInsensitiveNames matches the method name in any case,"system.time".
+	CaseInsensitiveNames
+	// SnakeCaseNames matches the snake_case method name,"git.publish_release".
+	SnakeCaseNames
+)
+
+// ParseNameMapping parses "exact","insensitive" or "snake".
+func ParseNameMapping(s string) (m NameMapping, err error) {
+	switch s {
+	case "exact", "":
+		return ExactNames, nil
+	case "insensitive":
+		return CaseInsensitiveNames, nil
+	case "snake":
+		return SnakeCaseNames, nil
+	}
+	return ExactNames, fmt.Errorf("rpc: unknown name mapping %q", s)
+}
+
+// key returns the routing key of the method name in a call.
+func (m NameMapping) key(name string) string {
+	if m == CaseInsensitiveNames {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+// mapped returns the name the Go method name is called as,
+// empty if it is only called by its Go name.
+func (m NameMapping) mapped(name string) string {
+	switch m {
+	case CaseInsensitiveNames:
+		return strings.ToLower(name)
+	case SnakeCaseNames:
+		return toSnake(name)
+	}
+	return ""
+}
+
+// toSnake converts a Go name to snake_case,"PublishRelease" is
+// "publish_release" and "GetURL" is "get_url".
+func toSnake(name string) string {
+	runes := []rune(name)
+	b := make([]rune, 0, len(runes)+4)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+				(i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1]))) {
+				b = append(b, '_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b = append(b, r)
+	}
+	return string(b)
+}
+
+// addName routes the call name key to method,it fails if key
+// already routes to another method. Must be called with the mutex held.
+func (m *serviceMap) addName(s *service, key, method string) error {
+	if key == "" {
+		return nil
+	}
+	key = m.mapping.key(key)
+	if other, ok := s.names[key]; ok && other != method {
+		return fmt.Errorf("rpc: %s.%s is both %s.%s and %s.%s", s.name, key, s.name, other, s.name, method)
+	}
+	if sm := s.methods[key]; sm != nil && key != method {
+		return fmt.Errorf("rpc: %s.%s is both %s.%s and %s.%s", s.name, key, s.name, key, s.name, method)
+	}
+	s.names[key] = method
+	return nil
+}
+
+// setMapping sets how call names map to method names,it must be set
+// before services are registered.
+func (m *serviceMap) setMapping(mapping NameMapping) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if len(m.services) > 0 {
+		return fmt.Errorf("rpc: name mapping must be set before services are registered")
+	}
+	m.mapping = mapping
+	return nil
+}
+
+// alias makes alias call method of the service name.
+func (m *serviceMap) alias(name, alias, method string) error {
+	if alias == "" || strings.Contains(alias, ".") {
+		return fmt.Errorf("rpc: alias ill-formed: %q", alias)
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	s := m.services[name]
+	if s == nil {
+		return fmt.Errorf("rpc: can't find service %q", name)
+	}
+	if s.proxy == nil && s.methods[method] == nil {
+		return fmt.Errorf("rpc: can't find method %q of alias %s.%s", name+"."+method, name, alias)
+	}
+	if err := m.addName(s, alias, method); err != nil {
+		return err
+	}
+	s.aliases[method] = append(s.aliases[method], alias)
+	return nil
+}
+
+// resolve returns the method name which the call name routes to.
+// Must be called with the mutex held.
+func (m *serviceMap) resolve(s *service, name string) string {
+	if _, ok := s.methods[name]; ok {
+		return name
+	}
+	if method, ok := s.names[m.mapping.key(name)]; ok {
+		return method
+	}
+	return name
+}
+
+// resolveProxy returns the method of the proxy which the call name routes
+// to,methods of proxies are mapped when called as they may change.
+func (m *serviceMap) resolveProxy(p Proxy, name string) string {
+	if m.mapping == ExactNames || p.HasMethod(name) {
+		return name
+	}
+	key := m.mapping.key(name)
+	for _, method := range p.Methods() {
+		if m.mapping.mapped(method) == key {
+			return method
+		}
+	}
+	return name
+}
@@ -0,0 +1,75 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+)
+
+// Peer is the identity of the process on the other end of a unix socket
+// connection,read with SO_PEERCRED.
+type Peer struct {
+	UID uint32 `json:"uid"`
+	GID uint32 `json:"gid"`
+	PID int32  `json:"pid"`
+}
+
+func (p *Peer) String() string {
+	return fmt.Sprintf("uid %d gid %d pid %d", p.UID, p.GID, p.PID)
+}
+
+type peerKey struct{}
+
+// PeerOf returns the peer of the unix socket connection r came in,
+// nil for other connections and in-process calls.
+func PeerOf(r *http.Request) *Peer {
+	if r == nil {
+		return nil
+	}
+	p, _ := r.Context().Value(peerKey{}).(*Peer)
+	return p
+}
+
+// connContext adds the peer of unix socket connections to ctx.
+func (s *Server) connContext(ctx context.Context, c net.Conn) context.Context {
+	uc, ok := unwrapConn(c).(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+	p, err := peerCred(uc)
+	if err != nil {
+		s.log.Warnf("read peer credentials of %s fail : %s", uc.LocalAddr(), err)
+		return ctx
+	}
+	return context.WithValue(ctx, peerKey{}, p)
+}
+
+// WithUnixListen listens on the unix socket path with the file mode,
+// a stale socket file is removed first. gid sets the group of the file
+// unless it is -1.
+func WithUnixListen(path string, mode os.FileMode, gid int) Option {
+	return func(s *Server) error {
+		if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			if c, err := net.Dial("unix", path); err == nil {
+				c.Close()
+				return fmt.Errorf("rpc: unix socket %s is in use", path)
+			}
+			os.Remove(path)
+		}
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			return err
+		}
+		if err = os.Chmod(path, mode); err == nil && gid != -1 {
+			err = os.Chown(path, -1, gid)
+		}
+		if err != nil {
+			l.Close()
+			return err
+		}
+		s.listeners = append(s.listeners, l)
+		return nil
+	}
+}
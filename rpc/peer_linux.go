@@ -0,0 +1,27 @@
+//go:build linux
+
+package rpc
+
+import (
+	"net"
+	"syscall"
+)
+
+// peerCred reads the credentials of the peer of c.
+func peerCred(c *net.UnixConn) (p *Peer, err error) {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return
+	}
+	var cred *syscall.Ucred
+	errControl := raw.Control(func(fd uintptr) {
+		cred, err = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if errControl != nil {
+		return nil, errControl
+	}
+	if err != nil {
+		return
+	}
+	return &Peer{UID: cred.Uid, GID: cred.Gid, PID: cred.Pid}, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package rpc
+
+import (
+	"errors"
+	"net"
+)
+
+// peerCred is only supported on linux.
+func peerCred(c *net.UnixConn) (p *Peer, err error) {
+	return nil, errors.New("SO_PEERCRED is not supported on this platform")
+}
@@ -0,0 +1,102 @@
+package rpc
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// rest mapping
+//
+// with WithREST methods are called without the json-rpc envelope,the
+// params object is posted as body,methods without params can be called
+// with GET:
+//   POST /<token>/git/Publish {"url":"..."}
+//   GET  /<token>/system/Time
+// the response is the plain json result with status 200,or the error
+// object {"code":...,"message":...,"data":...} with the status of its
+// code,see HTTPStatus. Calls go through the same auth,middleware and
+// dispatch as json-rpc calls,their Id is nil.
+
+// WithREST serves the rest mapping on /:token/<service>/<method>.
+func WithREST() Option {
+	return func(s *Server) error {
+		s.rest = true
+		return nil
+	}
+}
+
+// HTTPStatus returns the http status of a json-rpc error code.
+func HTTPStatus(code ErrorCode) int {
+	switch {
+	case code == E_PARSE, code == E_INVALID_REQ, code == E_BAD_PARAMS:
+		return http.StatusBadRequest
+	case code == E_NO_METHOD:
+		return http.StatusNotFound
+	case code == E_INTERNAL:
+		return http.StatusInternalServerError
+	case code <= -32000 && code >= -32099:
+		// implementation defined server errors,like failing proxies
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
+
+// serveREST calls method with the body as params,see WithREST.
+func (s *Server) serveREST(w http.ResponseWriter, r *http.Request, method string) {
+	c := &Call{Method: method, Request: r, Context: r.Context()}
+	if r.Method == "POST" {
+		body, err := s.readBody(w, r)
+		if err == errTooLarge {
+			writeREST(w, r, http.StatusRequestEntityTooLarge, &RPCError{Code: E_INVALID_REQ, Message: err.Error()})
+			return
+		}
+		if err != nil {
+			writeREST(w, r, http.StatusBadRequest, &RPCError{Code: E_PARSE, Message: err.Error()})
+			return
+		}
+		if cd := codecOf(r); cd != nil && len(body) > 0 {
+			if body, err = cd.toJSON(body); err != nil {
+				writeREST(w, r, http.StatusBadRequest, &RPCError{Code: E_PARSE, Message: err.Error()})
+				return
+			}
+		}
+		if len(body) > 0 {
+			if !json.Valid(body) {
+				writeREST(w, r, http.StatusBadRequest, &RPCError{Code: E_PARSE, Message: "body should be json"})
+				return
+			}
+			params := json.RawMessage(body)
+			c.Params = &params
+		}
+	}
+	result, err := s.invoke(c)
+	if err != nil {
+		rpcErr, ok := err.(*RPCError)
+		if !ok {
+			rpcErr = &RPCError{Code: E_INTERNAL, Message: err.Error()}
+		}
+		writeREST(w, r, HTTPStatus(rpcErr.Code), rpcErr)
+		return
+	}
+	writeREST(w, r, http.StatusOK, result)
+}
+
+// writeREST writes v as the response of r with status,encoded as json or
+// with the codec of r.
+func writeREST(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	contentType, marshal, newline := "application/json", json.Marshal, true
+	if cd := codecOf(r); cd != nil {
+		contentType, marshal, newline = cd.contentTypes[0], cd.marshal, false
+	}
+	body, err := marshal(v)
+	if err != nil {
+		status = http.StatusInternalServerError
+		body, _ = marshal(&RPCError{Code: E_INTERNAL, Message: err.Error()})
+	}
+	if newline {
+		body = append(body, '\n')
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	w.Write(body)
+}
@@ -0,0 +1,69 @@
+package rpc
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHTTPStatus(t *testing.T) {
+	tests := []struct {
+		code   ErrorCode
+		status int
+	}{
+		{E_PARSE, http.StatusBadRequest},
+		{E_INVALID_REQ, http.StatusBadRequest},
+		{E_BAD_PARAMS, http.StatusBadRequest},
+		{E_NO_METHOD, http.StatusNotFound},
+		{E_INTERNAL, http.StatusInternalServerError},
+		{E_SERVER, http.StatusBadGateway},
+		{-32099, http.StatusBadGateway},
+		{-32100, http.StatusInternalServerError},
+		{1, http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		if got := HTTPStatus(test.code); got != test.status {
+			t.Errorf("HTTPStatus(%d) = %d,want %d", test.code, got, test.status)
+		}
+	}
+}
+
+func TestREST(t *testing.T) {
+	_, ts := newTestServer(t, WithREST(), WithReadLimit(100))
+	tests := []struct {
+		method, path, contentType, body string
+		status                          int
+		response                        string // a part of the response body
+	}{
+		{"POST", "/t/test/Echo", "", `{"text":"a","times":2}`, 200, `"aa"`},
+		{"GET", "/t/test/Version", "", "", 200, `""`},
+		{"GET", "/t/test/Echo", "", "", 400, `"code":-32602`},
+		{"POST", "/t/test/Echo", "", `{"text":`, 400, `"code":-32700`},
+		{"POST", "/t/test/Fail", "", `-32001`, 502, `"message":"failed"`},
+		{"POST", "/t/test/Fail", "", `0`, 500, `"message":"plain error"`},
+		{"GET", "/t/test/Panic", "", "", 500, `"message":"boom"`},
+		{"GET", "/t/test/Nope", "", "", 404, `"code":-32601`},
+		{"GET", "/t/test/a/b", "", "", 404, `"not found"`},
+		{"GET", "/x/test/Version", "", "", 401, `"auth fail"`},
+		{"POST", "/t/test/Echo", "", `{"text":"` + strings.Repeat("a", 100) + `"}`, 413, `"code":-32600`},
+		{"POST", "/t", "", `{"jsonrpc":"2.0","method":"test.Echo","params":{"text":"` + strings.Repeat("a", 100) + `"},"id":1}`, 413, `"code":-32600`},
+		{"POST", "/t", "", `{"jsonrpc":"2.0","method":"test.Version","id":1}`, 200, `"result":""`},
+		{"POST", "/t/test/Echo", "application/cbor", "\xa2\x64text\x61a\x65times\x02", 200, "\x62aa"},
+	}
+	for _, test := range tests {
+		r, _ := http.NewRequest(test.method, ts.URL+test.path, strings.NewReader(test.body))
+		if test.contentType != "" {
+			r.Header.Set("Content-Type", test.contentType)
+		}
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != test.status || !strings.Contains(string(body), test.response) {
+			t.Errorf("%s %s %s : got %d %q,want %d %q", test.method, test.path, test.body, resp.StatusCode, body, test.status, test.response)
+		}
+	}
+}
@@ -0,0 +1,838 @@
+// Package rpc is the agentX json-rpc 2.0 server,it serves registered
+// services over http and websocket,see Server.
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/julienschmidt/httprouter"
+	logger "github.com/snail007/mini-logger"
+)
+
+type ErrorCode int
+
+const (
+	E_PARSE       ErrorCode = -32700
+	E_INVALID_REQ ErrorCode = -32600
+	E_NO_METHOD   ErrorCode = -32601
+	E_BAD_PARAMS  ErrorCode = -32602
+	E_INTERNAL    ErrorCode = -32603
+	E_SERVER      ErrorCode = -32000
+)
+
+var ErrNullResult = errors.New("result is null")
+
+type RPCError struct {
+	// A Number that indicates the error type that occurred.
+	Code ErrorCode `json:"code"` /* required */
+
+	// A String providing a short description of the error.
+	// The message SHOULD be limited to a concise single sentence.
+	Message interface{} `json:"message"` /* required */
+
+	// A Primitive or Structured value that contains additional information about the error.
+	Data interface{} `json:"data"` /* optional */
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("%s", e.Message)
+}
+
+var null = json.RawMessage([]byte("null"))
+
+// ----------------------------------------------------------------------------
+// Request and Response
+// ----------------------------------------------------------------------------
+
+// serverRequest represents a ProtoRPC request received by the server.
+type jsonRequest struct {
+	// JSON-RPC protocol.
+	Version string `json:"jsonrpc"`
+	// A String containing the name of the method to be invoked.
+	Method string `json:"method"`
+	// An Array of objects to pass as arguments to the method.
+	Params *json.RawMessage `json:"params"`
+	// The request id. This can be of any type. It is used to match the
+	// response with the request that it is replying to.
+	Id *json.RawMessage `json:"id"`
+}
+
+// serverResponse represents a ProtoRPC response returned by the server.
+type jsonResponse struct {
+	// JSON-RPC protocol.
+	Version string `json:"jsonrpc"`
+	// The Object that was returned by the invoked method. This must be null
+	// in case there was an error invoking the method.
+	Result interface{} `json:"result"`
+	// An Error object if there was an error invoking the method. It must be
+	// null if there was no error.
+	Error interface{} `json:"error"`
+	// This must be the same id as the request it is responding to.
+	Id *json.RawMessage `json:"id"`
+}
+
+// ----------------------------------------------------------------------------
+// Server
+// ----------------------------------------------------------------------------
+
+// AuthFunc checks the token in the url of a request,an error rejects it.
+type AuthFunc func(r *http.Request, token string) error
+
+// Call is a json-rpc call passed through the middleware.
+type Call struct {
+	// Method is "service.Method".
+	Method string
+	// Params is the raw params,nil if absent.
+	Params *json.RawMessage
+	// Id is the request id.
+	Id *json.RawMessage
+	// Request is the http request the call came in,the upgrade request
+	// for websocket,a GET of /<token> for raw tcp and nil for in-process calls.
+	Request *http.Request
+	// Context is done when the client is gone.
+	Context context.Context
+
+	// notify pushes a notification to the client,nil if the transport can't.
+	notify func(method string, params interface{}) error
+	// caller calls the client,nil if the transport can't.
+	caller *clientCaller
+}
+
+// Handler handles a call,err should be a *RPCError to set the error code.
+type Handler func(c *Call) (result interface{}, err error)
+
+// Middleware wraps a Handler.
+type Middleware func(next Handler) Handler
+
+// Option configures a Server.
+type Option func(s *Server) error
+
+// Server serves registered services over http and websocket,
+// json-rpc requests are posted or sent over websocket to /:token.
+type Server struct {
+	services   *serviceMap
+	listeners  []net.Listener
+	auth       AuthFunc
+	middleware []Middleware
+	handler    Handler
+	log        logger.MiniLogger
+	upgrader   websocket.Upgrader
+	mutex      sync.Mutex
+	servers    []*http.Server
+
+	rawListeners []net.Listener // raw tcp listeners,see WithTCPListen
+	rawConns     map[net.Conn]bool
+	mounts       []mount // handlers of other paths,see WithHandler
+	rest         bool    // serve the rest mapping,see WithREST
+	bus          *Bus
+	jobs         jobs
+
+	limits   atomic.Value          // Limits,see SetLimits
+	sessions map[string]*wsSession // resumable sessions,see WithSessions
+
+	compression      *Compression // nil if disabled,see WithCompression
+	statsMutex       sync.Mutex
+	compressionStats map[string]*CompressionStat
+
+	// ctx is done when the server is closed,it ends event streams,
+	// long polls and jobs
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// mount is a handler served on the paths under prefix.
+type mount struct {
+	prefix  string
+	handler http.Handler
+}
+
+// New returns a Server configured by opts.
+func New(opts ...Option) (s *Server, err error) {
+	s = &Server{
+		services: new(serviceMap),
+		bus:      NewBus(),
+		log:      logger.New(false, nil),
+		upgrader: websocket.Upgrader{
+			CheckOrigin:  func(r *http.Request) bool { return true },
+			Subprotocols: subprotocols(),
+		},
+	}
+	for _, opt := range opts {
+		if err = opt(s); err != nil {
+			for _, l := range append(s.listeners, s.rawListeners...) {
+				l.Close()
+			}
+			return nil, err
+		}
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.handler = s.dispatch
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		s.handler = s.middleware[i](s.handler)
+	}
+	return
+}
+
+// WithListen listens on the tcp address addr.
+func WithListen(addr string) Option {
+	return func(s *Server) error {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		s.listeners = append(s.listeners, l)
+		return nil
+	}
+}
+
+// WithListener serves on l.
+func WithListener(l net.Listener) Option {
+	return func(s *Server) error {
+		s.listeners = append(s.listeners, l)
+		return nil
+	}
+}
+
+// WithAuth checks each request with fn,all requests are allowed by default.
+func WithAuth(fn AuthFunc) Option {
+	return func(s *Server) error {
+		s.auth = fn
+		return nil
+	}
+}
+
+// WithMiddleware wraps the dispatch of each call with m,
+// the first middleware is the outermost.
+func WithMiddleware(m ...Middleware) Option {
+	return func(s *Server) error {
+		s.middleware = append(s.middleware, m...)
+		return nil
+	}
+}
+
+// WithService registers rcvr as the service name,see Register.
+func WithService(rcvr interface{}, name string) Option {
+	return func(s *Server) error {
+		return s.Register(rcvr, name)
+	}
+}
+
+// WithDefaultVersion sets the default version of the service name,
+// see SetDefaultVersion.
+func WithDefaultVersion(name, version string) Option {
+	return func(s *Server) error {
+		return s.SetDefaultVersion(name, version)
+	}
+}
+
+// WithNameMapping sets how method names in calls map to go method names,
+// it must precede the options registering services,see NameMapping.
+func WithNameMapping(mapping NameMapping) Option {
+	return func(s *Server) error {
+		return s.services.setMapping(mapping)
+	}
+}
+
+// WithHandler serves h on the paths under prefix,like "/console/",
+// requests of prefix without the trailing slash are redirected to it.
+// A token equal to the first segment of prefix can't be used in the url.
+func WithHandler(prefix string, h http.Handler) Option {
+	return func(s *Server) error {
+		if !strings.HasPrefix(prefix, "/") || !strings.HasSuffix(prefix, "/") || prefix == "/" {
+			return fmt.Errorf("rpc: handler prefix should look like /path/,got %q", prefix)
+		}
+		s.mounts = append(s.mounts, mount{prefix: prefix, handler: h})
+		return nil
+	}
+}
+
+// WithLogger logs to log,nothing is logged by default.
+func WithLogger(log logger.MiniLogger) Option {
+	return func(s *Server) error {
+		s.log = log
+		return nil
+	}
+}
+
+// Register adds the exported methods of rcvr as the service name,
+// methods look like:
+//   func (x *T) Method(args *Args, reply *Reply) error
+//   func (x *T) Method(reply *Reply) error
+// and may take a *Context as first argument,other methods are
+// skipped with a warning,see Check.
+func (s *Server) Register(rcvr interface{}, name string) error {
+	if err := s.services.register(rcvr, name); err != nil {
+		return err
+	}
+	if err := Check(rcvr); err != nil {
+		s.log.Warnf("service %q : %s", name, err)
+	}
+	return nil
+}
+
+// RegisterVersion registers rcvr as the version of the service name,
+// it is called as "name.version.Method",or "name.Method" if it is the
+// default version,see SetDefaultVersion.
+func (s *Server) RegisterVersion(rcvr interface{}, name, version string) error {
+	if !IsVersion(version) {
+		return fmt.Errorf("rpc: version ill-formed: %q", version)
+	}
+	return s.Register(rcvr, name+"."+version)
+}
+
+// SetDefaultVersion sets the version which "name.Method" resolves to
+// when name is not registered unversioned,it is the first registered
+// version by default.
+func (s *Server) SetDefaultVersion(name, version string) error {
+	return s.services.setDefaultVersion(name, version)
+}
+
+// RegisterProxy adds a service served by p.
+func (s *Server) RegisterProxy(p Proxy, name string) error {
+	return s.services.registerProxy(p, name)
+}
+
+// Init calls Init of the service name if it is an Initializer.
+func (s *Server) Init(name string, settings map[string]interface{}, log logger.MiniLogger) error {
+	return s.services.init(name, settings, log)
+}
+
+// Health returns the health of each service.
+func (s *Server) Health() (healthy bool, status map[string]string) {
+	return s.services.health()
+}
+
+// Handler returns the http handler of the server.
+func (s *Server) Handler() http.Handler {
+	router := httprouter.New()
+	router.Handle("GET", "/:token", s.serve)
+	router.Handle("POST", "/:token", s.serve)
+	router.Handle("OPTIONS", "/:token", s.serve)
+	router.Handle("GET", "/:token/*path", s.servePath)
+	router.Handle("POST", "/:token/*path", s.servePath)
+	router.Handle("OPTIONS", "/:token/*path", s.servePath)
+	var handler http.Handler = router
+	if len(s.mounts) > 0 {
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, m := range s.mounts {
+				if strings.HasPrefix(r.URL.Path, m.prefix) {
+					m.handler.ServeHTTP(w, r)
+					return
+				}
+				if r.URL.Path == strings.TrimSuffix(m.prefix, "/") {
+					http.Redirect(w, r, m.prefix, http.StatusMovedPermanently)
+					return
+				}
+			}
+			router.ServeHTTP(w, r)
+		})
+	}
+	if s.compression != nil && s.compression.HTTP {
+		handler = s.compress(handler)
+	}
+	return handler
+}
+
+// Start serves on the listeners in the background.
+func (s *Server) Start() {
+	handler := s.Handler()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, l := range s.listeners {
+		if s.compression != nil && s.compression.WebSocket {
+			l = countingListener{l}
+		}
+		srv := &http.Server{Handler: handler, ConnContext: s.connContext}
+		s.servers = append(s.servers, srv)
+		go func(l net.Listener) {
+			if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+				s.log.Errorf("serve %s fail : %s", l.Addr(), err)
+			}
+		}(l)
+	}
+	for _, l := range s.rawListeners {
+		go s.serveRaw(l)
+	}
+}
+
+// Addrs returns the addresses of the listeners.
+func (s *Server) Addrs() (addrs []net.Addr) {
+	for _, l := range s.listeners {
+		addrs = append(addrs, l.Addr())
+	}
+	for _, l := range s.rawListeners {
+		addrs = append(addrs, l.Addr())
+	}
+	return
+}
+
+// Close stops the listeners and closes the services before ctx is done.
+func (s *Server) Close(ctx context.Context) {
+	s.cancel()
+	s.mutex.Lock()
+	servers := s.servers
+	s.servers = nil
+	for _, l := range s.rawListeners {
+		l.Close()
+	}
+	for c := range s.rawConns {
+		c.Close()
+	}
+	s.mutex.Unlock()
+	for _, srv := range servers {
+		srv.Shutdown(ctx)
+	}
+	s.services.close(ctx, s.log)
+}
+
+// Process handles the json-rpc request or batch in body and returns the json
+// response,empty for a batch of notifications. r is the http request it
+// came in,nil for in-process calls.
+func (s *Server) Process(body []byte, r *http.Request) string {
+	ctx := context.Background()
+	if r != nil {
+		ctx = r.Context()
+	}
+	return s.handle(body, Call{Request: r, Context: ctx})
+}
+
+// handle handles a request or a batch of requests in body,c is the call
+// template of the transport. The responses of a batch are returned as an
+// array without those of notifications,empty if there is none.
+func (s *Server) handle(body []byte, c Call) string {
+	return string(s.handleCodec(nil, body, c))
+}
+
+// handleCodec is handle for the encoding cd,json if nil,it returns nil if
+// there is no response.
+func (s *Server) handleCodec(cd *codec, body []byte, c Call) []byte {
+	if cd != nil {
+		var err error
+		if body, err = cd.toJSON(body); err != nil {
+			return s.encode(cd, createErrorResponse(nil, E_PARSE, err.Error(), nil))
+		}
+	}
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		_, w := s.call(body, &c)
+		return s.encode(cd, w)
+	}
+	var batch []json.RawMessage
+	if err := json.Unmarshal(trimmed, &batch); err != nil || len(batch) == 0 {
+		msg := "empty batch"
+		if err != nil {
+			msg = err.Error()
+		}
+		return s.encode(cd, createErrorResponse(nil, E_INVALID_REQ, msg, nil))
+	}
+	responses := [][]byte{}
+	for _, message := range batch {
+		call := c
+		r, w := s.call(message, &call)
+		if r.Id == nil && r.Method != "" {
+			continue
+		}
+		responses = append(responses, s.encode(cd, w))
+	}
+	if len(responses) == 0 {
+		return nil
+	}
+	if cd == nil {
+		return append(append([]byte("["), bytes.Join(responses, []byte(","))...), ']')
+	}
+	e := cd.newEncoder()
+	e.writeArrayHeader(len(responses))
+	return append(e.bytes(), bytes.Join(responses, nil)...)
+}
+
+// encode marshals w with cd,json if nil,a result which can't be marshaled
+// is replaced by an internal error.
+func (s *Server) encode(cd *codec, w jsonResponse) []byte {
+	marshal := json.Marshal
+	if cd != nil {
+		marshal = cd.marshal
+	}
+	b, err := marshal(w)
+	if err != nil {
+		w.Result = nil
+		w.Error = &RPCError{Code: E_INTERNAL, Message: err.Error()}
+		b, _ = marshal(w)
+	}
+	return b
+}
+
+// call decodes the request in jsonBytes into c and handles it.
+func (s *Server) call(jsonBytes []byte, c *Call) (r jsonRequest, w jsonResponse) {
+	e := new(RPCError)
+	defer func() {
+		err1 := recover()
+		if err1 != nil {
+			e.Message = err1
+			e.Code = E_INTERNAL
+			w.Error = e
+		}
+	}()
+	w.Version = "2.0"
+	err := json.Unmarshal(jsonBytes, &r)
+	if err != nil {
+		e.Message = err.Error()
+		e.Code = E_PARSE
+		w.Error = e
+		return
+	}
+	if r.Version != "2.0" {
+		e.Message = "protocol error , only 2.0"
+		e.Code = E_INVALID_REQ
+		w.Error = e
+		return
+	}
+	w.Id = r.Id
+	w.Version = r.Version
+	c.Method, c.Params, c.Id = r.Method, r.Params, r.Id
+	result, err := s.handler(c)
+	if err != nil {
+		if rpcErr, ok := err.(*RPCError); ok {
+			w.Error = rpcErr
+			return
+		}
+		e.Message = err.Error()
+		e.Code = E_INTERNAL
+		w.Error = e
+		return
+	}
+	w.Result = result
+	return
+}
+
+// dispatch is the innermost Handler,it calls the service method.
+func (s *Server) dispatch(c *Call) (result interface{}, err error) {
+	switch c.Method {
+	case DiscoverMethod:
+		return s.Describe(), nil
+	case SubscribeMethod:
+		return s.subscribe(c)
+	case UnsubscribeMethod:
+		return s.unsubscribe(c)
+	case AsyncMethod:
+		return s.async(c)
+	}
+	// Get service method to be called.
+	serviceSpec, methodSpec, errGet := s.services.get(c.Method)
+	if errGet != nil {
+		return nil, &RPCError{Code: E_NO_METHOD, Message: errGet.Error()}
+	}
+	if serviceSpec.proxy != nil {
+		result, errCall := serviceSpec.proxy.Call(methodSpec.method.Name, c.Params)
+		if errCall != nil {
+			if rpcErr, ok := errCall.(*RPCError); ok {
+				return nil, rpcErr
+			}
+			return nil, &RPCError{Code: E_SERVER, Message: errCall.Error()}
+		}
+		return result, nil
+	}
+	reply := reflect.New(methodSpec.replyType)
+	in := []reflect.Value{serviceSpec.rcvr}
+	if methodSpec.hasContext {
+		ctx := &Context{
+			Context: c.Context,
+			Method:  c.Method,
+			Id:      c.Id,
+			Request: c.Request,
+			Peer:    PeerOf(c.Request),
+			Log:     s.log.With(logger.Fields{"method": c.Method}),
+			notify:  c.notify,
+			caller:  c.caller,
+			bus:     s.bus,
+		}
+		if ctx.Context == nil {
+			ctx.Context = context.Background()
+		}
+		in = append(in, reflect.ValueOf(ctx))
+	}
+	if methodSpec.argsType != nil {
+		if c.Params == nil {
+			return nil, &RPCError{Code: E_BAD_PARAMS, Message: "bad params"}
+		}
+		args := reflect.New(methodSpec.argsType)
+		err1 := json.Unmarshal(*c.Params, args.Interface())
+		if err1 != nil {
+			return nil, &RPCError{Code: E_BAD_PARAMS, Message: err1.Error()}
+		}
+		in = append(in, args)
+	}
+	errValue := methodSpec.method.Func.Call(append(in, reply))
+
+	// Cast the result to error if needed.
+	errInter := errValue[0].Interface()
+	if errInter != nil {
+		if rpcErr, ok := errInter.(*RPCError); ok {
+			return nil, rpcErr
+		}
+		return nil, &RPCError{Code: E_INTERNAL, Message: errInter.(error).Error()}
+	}
+	return reply.Interface(), nil
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if s.auth != nil {
+		if err := s.auth(r, ps.ByName("token")); err != nil {
+			id := json.RawMessage([]byte("0"))
+			response := createErrorResponse(&id, E_INVALID_REQ, "auth fail", nil)
+			body, e := json.Marshal(response)
+			if e == nil {
+				fmt.Fprint(w, string(body))
+				return
+			}
+			fmt.Fprint(w, "auth fail")
+			return
+		}
+	}
+	if isWS(r) {
+		s.serveWS(w, r, ps)
+	} else {
+		s.serveHTTP(w, r, ps)
+	}
+}
+
+// servePath serves the http endpoints under /:token/,the sse endpoint
+// rpc/events,the long-poll endpoint rpc/jobs/<id> and the rest mapping.
+func (s *Server) servePath(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Methods", "GET,POST,OPTIONS")
+	if r.Method == "OPTIONS" {
+		return
+	}
+	if s.auth != nil {
+		if err := s.auth(r, ps.ByName("token")); err != nil {
+			writeREST(w, r, http.StatusUnauthorized, &RPCError{Code: E_INVALID_REQ, Message: "auth fail"})
+			return
+		}
+	}
+	parts := strings.Split(strings.Trim(ps.ByName("path"), "/"), "/")
+	switch {
+	case len(parts) == 2 && parts[0] == "rpc" && parts[1] == "events" && r.Method == "GET":
+		s.serveEvents(w, r)
+	case len(parts) == 3 && parts[0] == "rpc" && parts[1] == "jobs" && r.Method == "GET":
+		s.serveJob(w, r, parts[2])
+	case len(parts) == 2 && s.rest:
+		s.serveREST(w, r, parts[0]+"."+parts[1])
+	default:
+		writeREST(w, r, http.StatusNotFound, &RPCError{Code: E_NO_METHOD, Message: "not found"})
+	}
+}
+
+// invoke calls the handler and turns a panic into an error.
+func (s *Server) invoke(c *Call) (result interface{}, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			result, err = nil, &RPCError{Code: E_INTERNAL, Message: fmt.Sprint(e)}
+		}
+	}()
+	return s.handler(c)
+}
+
+func createErrorResponse(id *json.RawMessage, errorcode ErrorCode, errmsg string, result interface{}) (response jsonResponse) {
+	r := new(jsonResponse)
+	r.Id = id
+	r.Version = "2.0"
+	r.Error = RPCError{
+		Code:    errorcode,
+		Message: errmsg,
+		Data:    result,
+	}
+	response = *r
+	return
+}
+func isWS(r *http.Request) bool {
+	if value := r.Header.Get("Upgrade"); strings.ToLower(value) != "websocket" {
+		return false
+	}
+	if value := r.Header.Get("Connection"); strings.ToLower(value) != "upgrade" {
+		return false
+	}
+	if value := r.Header.Get("Sec-WebSocket-Version"); strings.ToLower(value) != "13" {
+		return false
+	}
+	return true
+}
+
+func (s *Server) serveWS(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	c, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.log.Error("upgrade:", err)
+		return
+	}
+	done := make(chan bool)
+	defer close(done)
+	s.keepalive(c, done)
+	s.ServeWS(c, r)
+}
+
+// ServeWS serves newline-delimited json-rpc on the websocket connection c
+// until it is closed,r is the request it came in. Binary messages carry a
+// MessagePack or CBOR request if c has that subprotocol. The calls are
+// served one by one and may call the client,see Context.CallClient,they
+// go on when the connection is lost if it has a session,see WithSessions.
+// It serves connections which are not accepted by the server too,like one
+// dialed to a controller.
+func (s *Server) ServeWS(c *websocket.Conn, r *http.Request) {
+	defer c.Close()
+	limits := s.Limits()
+	if limits.ReadLimit > 0 {
+		c.SetReadLimit(limits.ReadLimit)
+	}
+	bin := codecByName(c.Subprotocol())
+	compression := s.newWSCompression(r, c.UnderlyingConn())
+	if compression != nil {
+		c.SetCompressionLevel(s.compression.Level)
+	}
+	var writeLock sync.Mutex
+	write := func(mt int, data []byte) error {
+		writeLock.Lock()
+		defer writeLock.Unlock()
+		if limits.WriteTimeout > 0 {
+			c.SetWriteDeadline(time.Now().Add(limits.WriteTimeout))
+		}
+		if compression != nil {
+			return compression.write(c, mt, data)
+		}
+		return c.WriteMessage(mt, data)
+	}
+	session := s.openSession(c, r, bin, write)
+	defer session.detach(c)
+	log := s.log.With(logger.Fields{"uri": r.RequestURI, "addr": r.RemoteAddr})
+	for {
+		mt, reader, err := c.NextReader()
+		if err != nil {
+			write(mt, []byte(err.Error()))
+			if websocket.IsCloseError(err) || websocket.IsUnexpectedCloseError(err) {
+				//log.Warn(err)
+			}
+			break
+		}
+		// the client is called in the message type and encoding of the call
+		send := func(data []byte) error {
+			return session.send(mt, data)
+		}
+		if bin != nil && mt == websocket.BinaryMessage {
+			message, err := ioutil.ReadAll(reader)
+			if err != nil {
+				break
+			}
+			caller := session.caller(mt, bin)
+			if caller.respond(message) {
+				continue
+			}
+			session.queue.push(func() {
+				response := s.handleCodec(bin, message, Call{Request: r, Context: session.ctx, notify: notifier(bin, send), caller: caller})
+				if response == nil {
+					return
+				}
+				if err := send(response); err != nil {
+					log.Warn("write:", err)
+				}
+			})
+			continue
+		}
+		caller := session.caller(mt, nil)
+		bufreader := bufio.NewReader(reader)
+		for {
+			var message []byte
+			message, err = bufreader.ReadBytes('\n')
+			message = bytes.TrimRight(message, "\r\n")
+			if err != nil {
+				break
+			}
+			if caller.respond(message) {
+				continue
+			}
+			session.queue.push(func() {
+				j := s.handle(message, Call{Request: r, Context: session.ctx, notify: notifier(nil, send), caller: caller})
+				if j == "" {
+					return
+				}
+				if err := send([]byte(j + "\n")); err != nil {
+					log.Warn("write:", err)
+				}
+			})
+		}
+	}
+}
+
+// notifier returns the notify func of a call,it writes notifications
+// encoded with cd,or json lines if cd is nil.
+func notifier(cd *codec, write func(data []byte) error) func(method string, params interface{}) error {
+	return func(method string, params interface{}) error {
+		notification := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}
+		if cd != nil {
+			b, err := cd.marshal(notification)
+			if err != nil {
+				return err
+			}
+			return write(b)
+		}
+		b, err := json.Marshal(notification)
+		if err != nil {
+			return err
+		}
+		return write(append(b, '\n'))
+	}
+}
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Methods", "POST,OPTIONS")
+	if r.Method == "OPTIONS" {
+		return
+	}
+	result, err := s.readBody(w, r)
+	if err == errTooLarge {
+		cd := codecOf(r)
+		if cd != nil {
+			w.Header().Set("Content-Type", cd.contentTypes[0])
+		}
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write(s.encode(cd, createErrorResponse(nil, E_INVALID_REQ, err.Error(), nil)))
+		return
+	}
+	if err != nil {
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if cd := codecOf(r); cd != nil {
+		w.Header().Set("Content-Type", cd.contentTypes[0])
+		w.Write(s.handleCodec(cd, result, Call{Request: r, Context: r.Context()}))
+		return
+	}
+	fmt.Fprint(w, s.handle(result, Call{Request: r, Context: r.Context()}))
+}
+
+// readBody reads the body of r,errTooLarge is returned if it exceeds the
+// read limit.
+func (s *Server) readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body := r.Body
+	if limit := s.Limits().ReadLimit; limit > 0 {
+		body = http.MaxBytesReader(w, r.Body, limit)
+	}
+	b, err := ioutil.ReadAll(body)
+	if _, ok := err.(*http.MaxBytesError); ok {
+		return nil, errTooLarge
+	}
+	return b, err
+}
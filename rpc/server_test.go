@@ -0,0 +1,222 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type testService struct {
+	version string
+	health  error
+}
+
+type EchoArgs struct {
+	Text  string `json:"text"`
+	Times int    `json:"times"`
+}
+
+func (x *testService) Echo(args *EchoArgs, reply *string) error {
+	*reply = strings.Repeat(args.Text, args.Times)
+	return nil
+}
+
+func (x *testService) Version(reply *string) error {
+	*reply = x.version
+	return nil
+}
+
+func (x *testService) Fail(args *int, reply *int) error {
+	if *args == 0 {
+		return errors.New("plain error")
+	}
+	return &RPCError{Code: ErrorCode(*args), Message: "failed"}
+}
+
+func (x *testService) Sleep(ms *int, reply *int) error {
+	time.Sleep(time.Duration(*ms) * time.Millisecond)
+	*reply = *ms
+	return nil
+}
+
+func (x *testService) Panic(reply *int) error {
+	panic("boom")
+}
+
+func (x *testService) Health() error {
+	return x.health
+}
+
+// newTestServer returns a server of the test services served by a test
+// http server,the token is "t".
+func newTestServer(t *testing.T, opts ...Option) (*Server, *httptest.Server) {
+	opts = append([]Option{
+		WithService(&testService{}, "test"),
+		WithAuth(func(r *http.Request, token string) error {
+			if token != "t" {
+				return errors.New("bad token")
+			}
+			return nil
+		}),
+	}, opts...)
+	s, err := New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(func() {
+		ts.Close()
+		s.Close(context.Background())
+	})
+	return s, ts
+}
+
+func TestProcess(t *testing.T) {
+	s, err := New(WithService(&testService{}, "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.RegisterVersion(&testService{version: "v1"}, "api", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.RegisterVersion(&testService{version: "v2"}, "api", "v2"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		request, response string
+	}{
+		{`{"jsonrpc":"2.0","method":"test.Echo","params":{"text":"a","times":3},"id":1}`,
+			`{"jsonrpc":"2.0","result":"aaa","error":null,"id":1}`},
+		{`[{"jsonrpc":"2.0","method":"test.Version","id":1},{"jsonrpc":"2.0","method":"test.Version"}]`,
+			`[{"jsonrpc":"2.0","result":"","error":null,"id":1}]`},
+		{`{"jsonrpc":"2.0","method":"api.Version","id":1}`,
+			`{"jsonrpc":"2.0","result":"v1","error":null,"id":1}`},
+		{`{"jsonrpc":"2.0","method":"api.v2.Version","id":1}`,
+			`{"jsonrpc":"2.0","result":"v2","error":null,"id":1}`},
+		{`{"jsonrpc":"2.0","method":"api.v3.Version","id":1}`, `"code":-32601`},
+		{`{"jsonrpc":"2.0","method":"test.Nope","id":1}`, `"code":-32601`},
+		{`{"jsonrpc":"2.0","method":"test.Echo","params":"x","id":1}`, `"code":-32602`},
+		{`{"jsonrpc":"2.0","method":"test.Echo","id":1}`, `"code":-32602`},
+		{`{"jsonrpc":"2.0","method":"test.Fail","params":0,"id":1}`, `"code":-32603,"message":"plain error"`},
+		{`{"jsonrpc":"2.0","method":"test.Fail","params":-32001,"id":1}`, `"code":-32001,"message":"failed"`},
+		{`{"jsonrpc":"2.0","method":"test.Panic","id":1}`, `"code":-32603,"message":"boom"`},
+		{`{"jsonrpc":"2.0",`, `"code":-32700`},
+	}
+	for _, test := range tests {
+		got := s.Process([]byte(test.request), nil)
+		if strings.HasPrefix(test.response, "{") || strings.HasPrefix(test.response, "[") {
+			if strings.TrimSpace(got) != test.response {
+				t.Errorf("%s : got %s,want %s", test.request, got, test.response)
+			}
+			continue
+		}
+		if !strings.Contains(got, test.response) {
+			t.Errorf("%s : got %s,want %s in it", test.request, got, test.response)
+		}
+	}
+	if err = s.SetDefaultVersion("api", "v2"); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Process([]byte(`{"jsonrpc":"2.0","method":"api.Version","id":1}`), nil); !strings.Contains(got, `"result":"v2"`) {
+		t.Errorf("default version v2 : got %s", got)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	healthy := &testService{}
+	sick := &testService{health: errors.New("down")}
+	s, err := New(WithService(healthy, "a"), WithService(sick, "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, status := s.Health()
+	if ok || status["a"] != "ok" || status["b"] != "down" {
+		t.Errorf("Health = %v,%v", ok, status)
+	}
+	sick.health = nil
+	if ok, status = s.Health(); !ok {
+		t.Errorf("Health = %v,%v", ok, status)
+	}
+}
+
+// dialWS dials the websocket of ts with the subprotocol,json if empty.
+func dialWS(t *testing.T, ts *httptest.Server, path, subprotocol string) *websocket.Conn {
+	dialer := websocket.Dialer{}
+	if subprotocol != "" {
+		dialer.Subprotocols = []string{subprotocol}
+	}
+	c, _, err := dialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return c
+}
+
+// readJSON reads a message of c as json.
+func readJSON(t *testing.T, c *websocket.Conn) map[string]interface{} {
+	mt, data, err := c.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cd := codecByName(c.Subprotocol()); cd != nil && mt == websocket.BinaryMessage {
+		if data, err = cd.toJSON(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var v map[string]interface{}
+	if err = json.Unmarshal(data, &v); err != nil {
+		t.Fatalf("bad message %s : %s", data, err)
+	}
+	return v
+}
+
+func TestWSRoundTrip(t *testing.T) {
+	_, ts := newTestServer(t)
+	request := map[string]interface{}{"jsonrpc": "2.0", "method": "test.Echo", "params": EchoArgs{"ab", 2}, "id": 7}
+	for _, subprotocol := range append([]string{""}, subprotocols()...) {
+		c := dialWS(t, ts, "/t", subprotocol)
+		if c.Subprotocol() != subprotocol {
+			t.Fatalf("subprotocol %q : negotiated %q", subprotocol, c.Subprotocol())
+		}
+		text := append(toJSON(request), '\n')
+		mt, data := websocket.TextMessage, text
+		if cd := codecByName(subprotocol); cd != nil {
+			var err error
+			if data, err = cd.marshal(request); err != nil {
+				t.Fatal(err)
+			}
+			mt = websocket.BinaryMessage
+		}
+		// json lines in text messages are served on every subprotocol
+		for _, m := range []struct {
+			mt   int
+			data []byte
+		}{{mt, data}, {websocket.TextMessage, text}} {
+			if err := c.WriteMessage(m.mt, m.data); err != nil {
+				t.Fatal(err)
+			}
+			response := readJSON(t, c)
+			if response["result"] != "abab" || response["id"] != 7.0 {
+				t.Errorf("subprotocol %q message type %d : got %v", subprotocol, m.mt, response)
+			}
+		}
+	}
+	// a rejected token is answered without the upgrade
+	if _, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/x", nil); err == nil {
+		t.Error("bad token : dialed")
+	}
+}
+
+func toJSON(v interface{}) []byte {
+	b, _ := json.Marshal(v)
+	return b
+}
@@ -0,0 +1,286 @@
+package rpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// resumable sessions
+//
+// a websocket client which connects with ?session=new gets a session,its id
+// is sent in a SessionMethod notification before anything else:
+//   <- {"jsonrpc":"2.0","method":"rpc.session","params":{"session":"<id>","resumed":false,"dropped":0}}
+// when the connection is lost the calls in flight go on and what the server
+// sends meanwhile,responses,notifications and calls of the client,is
+// buffered for the session ttl. The client reconnects with ?session=<id>
+// and gets the notification with resumed true and the buffered messages
+// after it,dropped counts those which did not fit in the buffer. An
+// unknown or expired session starts a new one,with resumed false.
+
+// SessionMethod is the method of the notification which starts a
+// connection with a session,params is
+// {"session":<id>,"resumed":<bool>,"dropped":<count>},the id is empty if
+// the server has no sessions.
+const SessionMethod = "rpc.session"
+
+// WithSessions keeps the sessions of websocket clients for ttl after their
+// connection is lost,buffering at most buffer messages for each. There
+// are no sessions by default.
+func WithSessions(ttl time.Duration, buffer int) Option {
+	return func(s *Server) error {
+		return s.setLimits(func(l *Limits) {
+			l.SessionTTL, l.SessionBuffer = ttl, buffer
+		})
+	}
+}
+
+// WithKeepalive pings websocket clients every ping and closes connections
+// when no pong is read within readTimeout,a write which does not end within
+// writeTimeout closes the connection too. Zero disables each of them.
+func WithKeepalive(ping, readTimeout, writeTimeout time.Duration) Option {
+	return func(s *Server) error {
+		return s.setLimits(func(l *Limits) {
+			l.Ping, l.ReadTimeout, l.WriteTimeout = ping, readTimeout, writeTimeout
+		})
+	}
+}
+
+// keepalive pings c until done is closed and extends the read deadline of c
+// on pongs.
+func (s *Server) keepalive(c *websocket.Conn, done chan bool) {
+	limits := s.Limits()
+	if limits.ReadTimeout > 0 {
+		c.SetReadDeadline(time.Now().Add(limits.ReadTimeout))
+		c.SetPongHandler(func(string) error {
+			return c.SetReadDeadline(time.Now().Add(limits.ReadTimeout))
+		})
+	}
+	if limits.Ping <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(limits.Ping)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := c.WriteControl(websocket.PingMessage, nil, time.Now().Add(limits.Ping)); err != nil {
+					c.Close()
+					return
+				}
+			}
+		}
+	}()
+}
+
+// wsMessage is a message buffered for a session.
+type wsMessage struct {
+	mt   int
+	data []byte
+}
+
+// wsSession is what the calls of a websocket connection share,their
+// context,queue and calls of the client. A resumable session,one with an
+// id,outlives its connection for the ttl of the server.
+type wsSession struct {
+	s      *Server
+	id     string // empty if not resumable
+	owner  string // the path of the upgrade request,which has the token
+	ctx    context.Context
+	cancel context.CancelFunc
+	queue  *callQueue
+
+	mutex   sync.Mutex
+	conn    *websocket.Conn // nil while detached
+	write   func(mt int, data []byte) error
+	callers map[int]*clientCaller
+	buffer  []wsMessage
+	dropped int
+	expiry  *time.Timer
+	closed  bool
+}
+
+func (s *Server) newSession(id, owner string) *wsSession {
+	ss := &wsSession{s: s, id: id, owner: owner, callers: map[int]*clientCaller{}}
+	ss.ctx, ss.cancel = context.WithCancel(s.ctx)
+	ss.queue = newCallQueue(ss.ctx)
+	return ss
+}
+
+// openSession returns the session of the websocket connection c upgraded
+// from r,write writes a message to c. A session is resumed or started if
+// r asks for it.
+func (s *Server) openSession(c *websocket.Conn, r *http.Request, bin *codec, write func(mt int, data []byte) error) *wsSession {
+	id := ""
+	if r != nil && r.URL != nil {
+		id = r.URL.Query().Get("session")
+	}
+	if id == "" || s.Limits().SessionTTL <= 0 {
+		ss := s.newSession("", "")
+		ss.conn, ss.write = c, write
+		if id != "" {
+			ss.hello(bin, write, false)
+		}
+		return ss
+	}
+	s.mutex.Lock()
+	ss := s.sessions[id]
+	s.mutex.Unlock()
+	if ss != nil && ss.owner == r.URL.Path && ss.attach(c, bin, write, true) {
+		return ss
+	}
+	ss = s.newSession(newSessionID(), r.URL.Path)
+	s.mutex.Lock()
+	if s.sessions == nil {
+		s.sessions = map[string]*wsSession{}
+	}
+	s.sessions[ss.id] = ss
+	s.mutex.Unlock()
+	ss.attach(c, bin, write, false)
+	return ss
+}
+
+func newSessionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// hello writes the SessionMethod notification.
+func (ss *wsSession) hello(bin *codec, write func(mt int, data []byte) error, resumed bool) error {
+	mt := websocket.TextMessage
+	if bin != nil {
+		mt = websocket.BinaryMessage
+	}
+	notify := notifier(bin, func(data []byte) error {
+		return write(mt, data)
+	})
+	return notify(SessionMethod, map[string]interface{}{"session": ss.id, "resumed": resumed, "dropped": ss.dropped})
+}
+
+// attach makes c the connection of the session and sends it the hello and
+// the buffered messages,a connection attached already is closed. It returns
+// false if the session expired.
+func (ss *wsSession) attach(c *websocket.Conn, bin *codec, write func(mt int, data []byte) error, resumed bool) bool {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	if ss.closed {
+		return false
+	}
+	if ss.expiry != nil {
+		ss.expiry.Stop()
+		ss.expiry = nil
+	}
+	if ss.conn != nil {
+		ss.conn.Close()
+	}
+	ss.conn, ss.write = c, write
+	if ss.hello(bin, write, resumed) != nil {
+		ss.detachLocked()
+		return true
+	}
+	ss.dropped = 0
+	for i, m := range ss.buffer {
+		if write(m.mt, m.data) != nil {
+			ss.buffer = ss.buffer[i:]
+			ss.detachLocked()
+			return true
+		}
+	}
+	ss.buffer = nil
+	return true
+}
+
+// send writes a message to the connection,or buffers it while there is
+// none. A connection which fails the write is closed.
+func (ss *wsSession) send(mt int, data []byte) error {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	if ss.write != nil {
+		err := ss.write(mt, data)
+		if err == nil {
+			return nil
+		}
+		if ss.id == "" {
+			ss.conn.Close()
+			return err
+		}
+		ss.detachLocked()
+	}
+	if ss.closed || ss.id == "" {
+		return ErrClientGone
+	}
+	ss.buffer = append(ss.buffer, wsMessage{mt: mt, data: data})
+	if len(ss.buffer) > ss.s.Limits().SessionBuffer {
+		ss.buffer = ss.buffer[1:]
+		ss.dropped++
+	}
+	return nil
+}
+
+// caller returns the caller of the client for messages of type mt.
+func (ss *wsSession) caller(mt int, cd *codec) *clientCaller {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	cc := ss.callers[mt]
+	if cc == nil {
+		cc = newClientCaller(cd, func(data []byte) error {
+			return ss.send(mt, data)
+		})
+		ss.callers[mt] = cc
+	}
+	return cc
+}
+
+// detach is called when the connection c is lost,the session is closed
+// unless it is resumable.
+func (ss *wsSession) detach(c *websocket.Conn) {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	if ss.conn == c {
+		ss.detachLocked()
+	}
+}
+
+func (ss *wsSession) detachLocked() {
+	ss.conn.Close()
+	ss.conn, ss.write = nil, nil
+	if ss.id == "" {
+		ss.closeLocked()
+		return
+	}
+	ss.expiry = time.AfterFunc(ss.s.Limits().SessionTTL, ss.expire)
+}
+
+// expire closes the session if no connection was attached meanwhile.
+func (ss *wsSession) expire() {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	if ss.conn == nil && !ss.closed {
+		ss.closeLocked()
+	}
+}
+
+// closeLocked ends the calls of the session.
+func (ss *wsSession) closeLocked() {
+	ss.closed = true
+	ss.buffer = nil
+	ss.cancel()
+	for _, cc := range ss.callers {
+		cc.close()
+	}
+	ss.queue.close()
+	if ss.id != "" {
+		ss.s.mutex.Lock()
+		delete(ss.s.sessions, ss.id)
+		ss.s.mutex.Unlock()
+	}
+}
@@ -0,0 +1,53 @@
+package rpc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// hello reads the session notification of c.
+func hello(t *testing.T, c *websocket.Conn) (id string, resumed bool) {
+	m := readJSON(t, c)
+	params, _ := m["params"].(map[string]interface{})
+	if m["method"] != SessionMethod || params == nil {
+		t.Fatalf("got %v,want the session notification", m)
+	}
+	id, _ = params["session"].(string)
+	resumed, _ = params["resumed"].(bool)
+	return
+}
+
+func TestSessionResume(t *testing.T) {
+	_, ts := newTestServer(t, WithSessions(time.Minute, 10))
+	c := dialWS(t, ts, "/t?session=new", "")
+	id, resumed := hello(t, c)
+	if id == "" || resumed {
+		t.Fatalf("new session %q resumed %v", id, resumed)
+	}
+	// the response of a call in flight is buffered while there is no connection
+	c.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","method":"test.Sleep","params":100,"id":3}`+"\n"))
+	time.Sleep(20 * time.Millisecond)
+	c.Close()
+	tests := []struct {
+		session string
+		resumed bool
+	}{
+		{id, true},
+		{"unknown", false},
+	}
+	for _, test := range tests {
+		c = dialWS(t, ts, "/t?session="+test.session, "")
+		got, resumed := hello(t, c)
+		if resumed != test.resumed || (got == test.session) != test.resumed {
+			t.Errorf("session %q : got %q resumed %v,want resumed %v", test.session, got, resumed, test.resumed)
+		}
+		if !test.resumed {
+			continue
+		}
+		if m := readJSON(t, c); m["result"] != 100.0 || m["id"] != 3.0 {
+			t.Errorf("session %q : got %v,want the buffered response", test.session, m)
+		}
+	}
+}
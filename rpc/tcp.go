@@ -0,0 +1,203 @@
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	logger "github.com/snail007/mini-logger"
+)
+
+// raw tcp protocol
+//
+// a client of the raw tcp listener sends newline-delimited json-rpc requests
+// and reads newline-delimited responses and notifications,like over
+// websocket. The first line is the handshake which carries the token,either
+// the bare token or {"token":"<token>"},a rejected handshake is answered
+// with an error response and the connection is closed:
+//   -> s3cret
+//   -> {"jsonrpc":"2.0","method":"system.Time","id":1}
+//   <- {"jsonrpc":"2.0","result":"1510000000","error":null,"id":1}
+
+// WithTCPListen serves newline-delimited json-rpc on the tcp address addr,
+// wrapped in tls if config is not nil.
+func WithTCPListen(addr string, config *tls.Config) Option {
+	return func(s *Server) error {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		if config != nil {
+			l = tls.NewListener(l, config)
+		}
+		s.rawListeners = append(s.rawListeners, l)
+		return nil
+	}
+}
+
+// WithReadLimit limits the size of a http request body,a websocket message
+// and a raw tcp line to n bytes,a larger body gets a 413 with an
+// E_INVALID_REQ error and a connection is closed. No limit if n <= 0.
+func WithReadLimit(n int64) Option {
+	return func(s *Server) error {
+		return s.setLimits(func(l *Limits) {
+			l.ReadLimit = n
+		})
+	}
+}
+
+// serveRaw accepts raw tcp connections on l until it is closed.
+func (s *Server) serveRaw(l net.Listener) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			if !strings.Contains(err.Error(), "use of closed network connection") {
+				s.log.Errorf("serve %s fail : %s", l.Addr(), err)
+			}
+			return
+		}
+		s.mutex.Lock()
+		if s.rawConns == nil {
+			s.rawConns = map[net.Conn]bool{}
+		}
+		s.rawConns[c] = true
+		s.mutex.Unlock()
+		go func() {
+			s.serveConn(c)
+			s.mutex.Lock()
+			delete(s.rawConns, c)
+			s.mutex.Unlock()
+		}()
+	}
+}
+
+// serveConn does the handshake and serves the calls of a raw tcp connection
+// one by one,like ServeWS. A client which closes its side of the connection
+// after the last request,like `nc -N`,gets the responses of the calls it
+// sent,they are canceled only when the connection breaks,which is noticed
+// on a read error or a failed write.
+func (s *Server) serveConn(c net.Conn) {
+	defer c.Close()
+	log := s.log.With(logger.Fields{"addr": c.RemoteAddr().String()})
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	reader := bufio.NewReaderSize(c, 64*1024)
+	var writeLock sync.Mutex
+	write := func(data []byte) error {
+		writeLock.Lock()
+		defer writeLock.Unlock()
+		_, err := c.Write(append(data, '\n'))
+		return err
+	}
+	line, err := ReadLine(reader, s.Limits().ReadLimit)
+	if err != nil {
+		return
+	}
+	token := string(bytes.TrimSpace(line))
+	if strings.HasPrefix(token, "{") {
+		var handshake struct {
+			Token string `json:"token"`
+		}
+		json.Unmarshal(line, &handshake)
+		token = handshake.Token
+	}
+	// the request is what auth and the services see of the connection
+	r, err := http.NewRequest("GET", "/"+url.PathEscape(token), nil)
+	if err != nil {
+		return
+	}
+	r = r.WithContext(ctx)
+	r.RemoteAddr = c.RemoteAddr().String()
+	r.RequestURI = r.URL.RequestURI()
+	if s.auth != nil {
+		if err := s.auth(r, token); err != nil {
+			id := json.RawMessage([]byte("0"))
+			body, _ := json.Marshal(createErrorResponse(&id, E_INVALID_REQ, "auth fail", nil))
+			write(body)
+			return
+		}
+	}
+	notify := func(method string, params interface{}) error {
+		b, err := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params})
+		if err != nil {
+			return err
+		}
+		return write(b)
+	}
+	caller := newClientCaller(nil, func(data []byte) error {
+		return write(bytes.TrimSuffix(data, []byte("\n")))
+	})
+	queue := newCallQueue(ctx)
+	defer func() {
+		cancel()
+		caller.close()
+		queue.close()
+	}()
+	for {
+		line, err := ReadLine(reader, s.Limits().ReadLimit)
+		if err == io.EOF {
+			// no response of the client can come,the calls queued are
+			// served before the connection is closed
+			caller.close()
+			queue.close()
+			queue.wait()
+			return
+		}
+		if err != nil {
+			if err != errTooLarge {
+				return
+			}
+			body, _ := json.Marshal(createErrorResponse(nil, E_INVALID_REQ, err.Error(), nil))
+			write(body)
+			return
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || caller.respond(line) {
+			continue
+		}
+		queue.push(func() {
+			j := s.handle(line, Call{Request: r, Context: ctx, notify: notify, caller: caller})
+			if j == "" {
+				return
+			}
+			if err := write([]byte(j)); err != nil {
+				log.Warn("write:", err)
+				cancel()
+				c.Close()
+			}
+		})
+	}
+}
+
+var errTooLarge = fmt.Errorf("rpc: message exceeds the read limit")
+
+// ReadLine reads a line without the line break,of at most max bytes if max > 0.
+func ReadLine(reader *bufio.Reader, max int64) (line []byte, err error) {
+	for {
+		var part []byte
+		var isPrefix bool
+		part, isPrefix, err = reader.ReadLine()
+		if err != nil {
+			return
+		}
+		line = append(line, part...)
+		if max > 0 && int64(len(line)) > max {
+			return nil, errTooLarge
+		}
+		if !isPrefix {
+			return
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package rpc
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadLine(t *testing.T) {
+	tests := []struct {
+		input string
+		max   int64
+		lines []string // the lines read before err
+		err   error
+	}{
+		{"a\nb\n", 0, []string{"a", "b"}, nil},
+		{"a\r\nb", 0, []string{"a", "b"}, nil},
+		{"abc\nabcd\n", 3, []string{"abc"}, errTooLarge},
+		{strings.Repeat("a", 20) + "\n", 0, []string{strings.Repeat("a", 20)}, nil},
+		{strings.Repeat("a", 20) + "\n", 19, nil, errTooLarge},
+		{"\n\n", 1, []string{"", ""}, nil},
+	}
+	for _, test := range tests {
+		// a reader smaller than the lines,which are read in parts
+		reader := bufio.NewReaderSize(strings.NewReader(test.input), 16)
+		var lines []string
+		var err error
+		for {
+			var line []byte
+			if line, err = ReadLine(reader, test.max); err != nil {
+				break
+			}
+			lines = append(lines, string(line))
+		}
+		want := test.err
+		if want == nil {
+			want = io.EOF
+		}
+		if err != want || strings.Join(lines, "|") != strings.Join(test.lines, "|") {
+			t.Errorf("%q max %d : got %q,%v,want %q,%v", test.input, test.max, lines, err, test.lines, want)
+		}
+	}
+}
+
+func TestTCP(t *testing.T) {
+	s, _ := newTestServer(t, WithTCPListen("127.0.0.1:0", nil), WithReadLimit(200))
+	s.Start()
+	addr := s.Addrs()[0].String()
+	tests := []struct {
+		name      string
+		input     string
+		halfClose bool
+		responses []string // parts of the responses,in order
+	}{
+		{"token", "t\n" + `{"jsonrpc":"2.0","method":"test.Echo","params":{"text":"a","times":2},"id":1}` + "\n",
+			false, []string{`"result":"aa"`}},
+		{"handshake", `{"token":"t"}` + "\n" + `{"jsonrpc":"2.0","method":"test.Version","id":1}` + "\n",
+			false, []string{`"result":""`}},
+		{"bad token", "x\n" + `{"jsonrpc":"2.0","method":"test.Version","id":1}` + "\n",
+			false, []string{`"auth fail"`}},
+		{"too large", "t\n" + `{"jsonrpc":"2.0","method":"test.Echo","params":{"text":"` + strings.Repeat("a", 200) + `"},"id":1}` + "\n",
+			false, []string{`"code":-32600`}},
+		{"half-close", "t\n" + strings.Repeat(`{"jsonrpc":"2.0","method":"test.Echo","params":{"text":"b","times":1},"id":2}`+"\n", 5),
+			true, []string{`"result":"b"`, `"result":"b"`, `"result":"b"`, `"result":"b"`, `"result":"b"`}},
+	}
+	for _, test := range tests {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err = c.Write([]byte(test.input)); err != nil {
+			t.Fatal(err)
+		}
+		if test.halfClose {
+			c.(*net.TCPConn).CloseWrite()
+		}
+		reader := bufio.NewReader(c)
+		for i, want := range test.responses {
+			line, err := reader.ReadString('\n')
+			if err != nil || !strings.Contains(line, want) || !json.Valid([]byte(line)) {
+				t.Errorf("%s : response %d %q,%v,want %s", test.name, i, line, err, want)
+				break
+			}
+		}
+		// the connection is closed after the error or the last response
+		if test.halfClose || test.name == "bad token" || test.name == "too large" {
+			if line, err := reader.ReadString('\n'); err == nil {
+				t.Errorf("%s : got %q after the last response,want EOF", test.name, line)
+			}
+		}
+		c.Close()
+	}
+}
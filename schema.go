@@ -0,0 +1,447 @@
+package main
+
+import (
+	"agentX/rpc"
+	"fmt"
+	"net"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+//Config is the typed agentX config.
+//each field is tagged with its config key,
+//flag:"name" binds a cli flag and secret:"true" masks the value when printed.
+type Config struct {
+	AgentX   AgentXConfig   `config:"agentX"`
+	RPC      RPCConfig      `config:"rpc"`
+	Log      LogConfig      `config:"log"`
+	Reverse  ReverseConfig  `config:"reverse"`
+	Register RegisterConfig `config:"register"`
+	//Plugins is [plugins.<id>],see PluginConfig
+	Plugins map[string]interface{} `config:"plugins"`
+
+	file     string   //main config file used
+	includes []string //conf.d files merged in order
+}
+type AgentXConfig struct {
+	Version string `config:"version"`
+	//ShutdownTimeout is the seconds to wait for services to close
+	ShutdownTimeout int `config:"shutdownTimeout"`
+}
+type RPCConfig struct {
+	Listen string `config:"listen" flag:"rpc-listen"`
+	//DefaultVersion is [rpc.defaultVersion],service = "v1" makes calls of
+	//"service.Method" go to "service.v1.Method"
+	DefaultVersion map[string]interface{} `config:"defaultVersion"`
+	//MethodNames is how method names in calls match go method names,
+	//"exact","insensitive" or "snake"
+	MethodNames string `config:"methodNames"`
+	//Tokens are the tokens accepted in the url,any token is accepted when empty
+	Tokens []string   `config:"tokens"`
+	Unix   UnixConfig `config:"unix"`
+	TCP    TCPConfig  `config:"tcp"`
+	//ReadLimit is the max bytes of a request body,websocket message or raw
+	//tcp line
+	ReadLimit int64 `config:"readLimit"`
+	//Console is the path the web console is served on,disabled when empty
+	Console string `config:"console"`
+	//REST serves methods on /<token>/<service>/<method> without the
+	//json-rpc envelope
+	REST        bool              `config:"rest"`
+	Compression CompressionConfig `config:"compression"`
+	WebSocket   WebSocketConfig   `config:"websocket"`
+}
+
+//WebSocketConfig is [rpc.websocket],the keepalive and resumable sessions
+//of websocket clients,times are in seconds,0 disables
+type WebSocketConfig struct {
+	//Ping is the interval of pings
+	Ping int `config:"ping"`
+	//ReadTimeout closes a connection which sent no pong for it
+	ReadTimeout int `config:"readTimeout"`
+	//WriteTimeout closes a connection which takes longer to write a message
+	WriteTimeout int `config:"writeTimeout"`
+	//SessionTTL is how long the session of a lost connection is kept
+	SessionTTL int `config:"sessionTTL"`
+	//SessionBuffer is the max messages buffered for a lost connection
+	SessionBuffer int `config:"sessionBuffer"`
+}
+
+//CompressionConfig is [rpc.compression],negotiated with each client
+type CompressionConfig struct {
+	//HTTP compresses http responses with gzip or deflate
+	HTTP bool `config:"http"`
+	//WebSocket compresses websocket messages with permessage-deflate
+	WebSocket bool `config:"websocket"`
+	//Threshold is the min bytes of a response or message to compress
+	Threshold int `config:"threshold"`
+	//Level is the flate level,-1 is the default,1 the fastest,9 the best
+	Level int `config:"level"`
+}
+
+//TCPConfig is [rpc.tcp],the raw tcp listener of newline-delimited json-rpc
+type TCPConfig struct {
+	//Listen is the tcp address,no raw tcp listener when empty
+	Listen string `config:"listen"`
+	//TLSCert and TLSKey are pem files,tls is used when both are set
+	TLSCert string `config:"tlsCert"`
+	TLSKey  string `config:"tlsKey"`
+}
+
+//UnixConfig is [rpc.unix],the unix socket listener serving the same
+//protocol as rpc.listen
+type UnixConfig struct {
+	//Path is the socket file,no unix listener when empty
+	Path string `config:"path"`
+	//Mode is the octal file mode of the socket like "0660"
+	Mode string `config:"mode"`
+	//Group owns the socket file when set
+	Group string `config:"group"`
+	//TrustedUsers are user names or uids which need no token on the socket
+	TrustedUsers []string `config:"trustedUsers"`
+}
+
+//ReverseConfig is [reverse],the controller the agent dials out to
+type ReverseConfig struct {
+	//URL is the ws:// or wss:// url of the controller,disabled when empty
+	URL string `config:"url"`
+	//ID identifies the agent to the controller,the hostname by default
+	ID    string `config:"id"`
+	Token string `config:"token"`
+	//Heartbeat is the seconds between pings,the link is closed after
+	//three heartbeats without pong
+	Heartbeat          int    `config:"heartbeat"`
+	CAFile             string `config:"caFile"`
+	InsecureSkipVerify bool   `config:"insecureSkipVerify"`
+}
+
+//RegisterConfig is [register],the endpoint the agent reports itself to
+type RegisterConfig struct {
+	//URL is the http:// or https:// endpoint,disabled when empty
+	URL string `config:"url"`
+	//Method makes the report the params of a json-rpc call of it
+	Method string `config:"method"`
+	Token  string `config:"token"`
+	//Interval is the seconds between reports
+	Interval int `config:"interval"`
+	//IDFile stores the stable agent id,a relative path is in the directory
+	//of the config file
+	IDFile string `config:"idFile"`
+}
+type LogConfig struct {
+	Dir          string   `config:"dir" flag:"log-dir"`
+	Level        []string `config:"level" flag:"log-level"`
+	ConsoleLevel string   `config:"console-level" flag:"level"`
+	FileMaxSize  int64    `config:"fileMaxSize" flag:"log-max-size"`
+	MaxCount     int      `config:"maxCount" flag:"log-max-count"`
+}
+
+//validate checks values which can be decoded but are not usable
+func (c *Config) validate() (errs []string) {
+	if _, _, err := net.SplitHostPort(c.RPC.Listen); err != nil {
+		errs = append(errs, fmt.Sprintf("rpc.listen : %s", err))
+	}
+	if _, ok := consoleLevels[c.Log.ConsoleLevel]; !ok {
+		errs = append(errs, fmt.Sprintf("log.console-level : %q is unknown,should be one of debug,info,warn,error,fatal", c.Log.ConsoleLevel))
+	}
+	for _, l := range c.Log.Level {
+		if l != "debug" && l != "info" && l != "error" {
+			errs = append(errs, fmt.Sprintf("log.level : %q is unknown,should be some of debug,info,error", l))
+		}
+	}
+	for name, v := range c.RPC.DefaultVersion {
+		if version, ok := v.(string); !ok || !rpc.IsVersion(version) {
+			errs = append(errs, fmt.Sprintf("rpc.defaultVersion.%s : expected version like v1,got %#v", name, v))
+		}
+	}
+	errs = append(errs, c.RPC.Unix.validate()...)
+	errs = append(errs, c.RPC.TCP.validate()...)
+	errs = append(errs, c.Reverse.validate()...)
+	errs = append(errs, c.Register.validate()...)
+	if p := c.RPC.Console; p != "" && (!strings.HasPrefix(p, "/") || !strings.HasSuffix(p, "/") || p == "/") {
+		errs = append(errs, fmt.Sprintf("rpc.console : %q should look like /console/", p))
+	}
+	if c := c.RPC.Compression; c.Level < -2 || c.Level > 9 {
+		errs = append(errs, fmt.Sprintf("rpc.compression.level : should be -2 to 9,got %d", c.Level))
+	}
+	if ws := c.RPC.WebSocket; ws.Ping < 0 || ws.ReadTimeout < 0 || ws.WriteTimeout < 0 || ws.SessionTTL < 0 {
+		errs = append(errs, "rpc.websocket : times should not be negative")
+	} else if ws.ReadTimeout > 0 && (ws.Ping == 0 || ws.Ping >= ws.ReadTimeout) {
+		errs = append(errs, "rpc.websocket.ping : should be greater than 0 and less than readTimeout")
+	}
+	if ws := c.RPC.WebSocket; ws.SessionTTL > 0 && ws.SessionBuffer <= 0 {
+		errs = append(errs, "rpc.websocket.sessionBuffer : should be greater than 0")
+	}
+	if c.RPC.Compression.Threshold < 0 {
+		errs = append(errs, "rpc.compression.threshold : should not be negative")
+	}
+	if c.RPC.ReadLimit <= 0 {
+		errs = append(errs, "rpc.readLimit : should be greater than 0")
+	}
+	if _, err := rpc.ParseNameMapping(c.RPC.MethodNames); err != nil {
+		errs = append(errs, fmt.Sprintf("rpc.methodNames : %q is unknown,should be one of exact,insensitive,snake", c.RPC.MethodNames))
+	}
+	if c.AgentX.ShutdownTimeout <= 0 {
+		errs = append(errs, "agentX.shutdownTimeout : should be greater than 0")
+	}
+	if c.Log.FileMaxSize <= 0 {
+		errs = append(errs, "log.fileMaxSize : should be greater than 0")
+	}
+	if c.Log.MaxCount <= 0 {
+		errs = append(errs, "log.maxCount : should be greater than 0")
+	}
+	errs = append(errs, c.validatePlugins()...)
+	return
+}
+
+//configField is a leaf of the Config schema
+type configField struct {
+	key    string //dotted config key
+	flag   string
+	secret bool
+	value  reflect.Value
+}
+
+//configFields walks v (a *Config) and returns its leaf fields
+func configFields(v interface{}) (fields []configField) {
+	var walk func(prefix string, v reflect.Value)
+	walk = func(prefix string, v reflect.Value) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			key := f.Tag.Get("config")
+			if key == "" {
+				continue
+			}
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			if f.Type.Kind() == reflect.Struct {
+				walk(key, v.Field(i))
+				continue
+			}
+			fields = append(fields, configField{
+				key:    key,
+				flag:   f.Tag.Get("flag"),
+				secret: f.Tag.Get("secret") == "true",
+				value:  v.Field(i),
+			})
+		}
+	}
+	walk("", reflect.ValueOf(v).Elem())
+	return
+}
+
+//bindConfig binds cli flags and AGENTX_* environment variables to the schema keys,
+//AGENTX_LOG_CONSOLE_LEVEL overrides log.console-level for example.
+func bindConfig(v *viper.Viper) {
+	v.SetEnvPrefix("AGENTX")
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
+	for _, f := range configFields(new(Config)) {
+		if f.value.Kind() == reflect.Map {
+			continue
+		}
+		v.BindEnv(f.key)
+		if f.flag != "" {
+			v.BindPFlag(f.key, pflag.Lookup(f.flag))
+		}
+	}
+}
+
+//decodeConfig decodes v into c,unknown keys and bad values are all reported
+func decodeConfig(v *viper.Viper, c *Config) (errs []string) {
+	fields := configFields(c)
+	known := map[string]bool{}
+	var maps []string
+	for _, f := range fields {
+		known[strings.ToLower(f.key)] = true
+		if f.value.Kind() == reflect.Map {
+			maps = append(maps, strings.ToLower(f.key)+".")
+		}
+	}
+	keys := v.AllKeys()
+	sort.Strings(keys)
+next:
+	for _, k := range keys {
+		if known[k] {
+			continue
+		}
+		for _, m := range maps {
+			if strings.HasPrefix(k, m) {
+				continue next
+			}
+		}
+		errs = append(errs, fmt.Sprintf("%s : unknown key", k))
+	}
+	for _, f := range fields {
+		val := v.Get(f.key)
+		if val == nil {
+			continue
+		}
+		if err := setConfigValue(f.value, val); err != nil {
+			errs = append(errs, fmt.Sprintf("%s : %s", f.key, err))
+		}
+	}
+	return
+}
+
+func setConfigValue(dst reflect.Value, val interface{}) (err error) {
+	switch dst.Kind() {
+	case reflect.String:
+		var s string
+		if s, err = cast.ToStringE(val); err == nil {
+			dst.SetString(s)
+		}
+	case reflect.Bool:
+		var b bool
+		if b, err = cast.ToBoolE(val); err == nil {
+			dst.SetBool(b)
+		}
+	case reflect.Int, reflect.Int64:
+		var i int64
+		if i, err = cast.ToInt64E(val); err == nil {
+			dst.SetInt(i)
+		}
+	case reflect.Slice:
+		var s []string
+		if str, ok := val.(string); ok {
+			//flags and env give "a,b" or "[a,b]"
+			str = strings.TrimSuffix(strings.TrimPrefix(str, "["), "]")
+			for _, v := range strings.Split(str, ",") {
+				if v = strings.TrimSpace(v); v != "" {
+					s = append(s, v)
+				}
+			}
+		} else if s, err = cast.ToStringSliceE(val); err != nil {
+			break
+		}
+		dst.Set(reflect.ValueOf(s))
+	case reflect.Map:
+		var m map[string]interface{}
+		if m, err = cast.ToStringMapE(val); err == nil {
+			dst.Set(reflect.ValueOf(m))
+		}
+	default:
+		err = fmt.Errorf("unsupported type %s", dst.Type())
+	}
+	if err != nil {
+		err = fmt.Errorf("expected %s,got %#v", dst.Type(), val)
+	}
+	return
+}
+
+//secretKey matches keys whose values are masked when printed or logged
+var secretKey = regexp.MustCompile(`(?i)(token|password|passwd|secret|sshkey|private)`)
+
+//flatten returns the dotted keys and printable values of c,secrets are
+//masked if mask is true
+func (c *Config) flatten(mask bool) (keys []string, values map[string]string) {
+	values = map[string]string{}
+	var add func(key string, secret bool, v interface{})
+	add = func(key string, secret bool, v interface{}) {
+		if m, ok := v.(map[string]interface{}); ok {
+			for k, v := range m {
+				if strings.ContainsAny(k, ". ") {
+					k = strconv.Quote(k)
+				}
+				add(key+"."+k, secret, v)
+			}
+			return
+		}
+		if mask && (secret || secretKey.MatchString(key[lastKeyDot(key)+1:])) {
+			values[key] = `"******"`
+		} else {
+			values[key] = tomlValue(v)
+		}
+		keys = append(keys, key)
+	}
+	for _, f := range configFields(c) {
+		add(f.key, f.secret, f.value.Interface())
+	}
+	sort.Strings(keys)
+	return
+}
+
+//diff returns a sorted summary of keys changed from c to n,the values are
+//compared unmasked and printed masked
+func (c *Config) diff(n *Config) (changes []string) {
+	oldKeys, oldValues := c.flatten(false)
+	newKeys, newValues := n.flatten(false)
+	_, oldPrinted := c.flatten(true)
+	_, newPrinted := n.flatten(true)
+	for _, k := range newKeys {
+		if o, ok := oldValues[k]; !ok {
+			changes = append(changes, fmt.Sprintf("+%s=%s", k, newPrinted[k]))
+		} else if o != newValues[k] {
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", k, oldPrinted[k], newPrinted[k]))
+		}
+	}
+	for _, k := range oldKeys {
+		if _, ok := newValues[k]; !ok {
+			changes = append(changes, fmt.Sprintf("-%s", k))
+		}
+	}
+	sort.Strings(changes)
+	return
+}
+
+//toml renders c as toml,secrets are masked
+func (c *Config) toml() string {
+	keys, values := c.flatten(true)
+	buf := []string{}
+	section := ""
+	for _, k := range keys {
+		i := lastKeyDot(k)
+		if k[:i] != section {
+			section = k[:i]
+			if len(buf) > 0 {
+				buf = append(buf, "")
+			}
+			buf = append(buf, "["+section+"]")
+		}
+		buf = append(buf, fmt.Sprintf("%s = %s", k[i+1:], values[k]))
+	}
+	return strings.Join(buf, "\n") + "\n"
+}
+
+//lastKeyDot returns the index of the last dot of key which is not quoted
+func lastKeyDot(key string) (i int) {
+	quoted := false
+	i = -1
+	for j, c := range key {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == '.' && !quoted:
+			i = j
+		}
+	}
+	return
+}
+
+func tomlValue(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return strconv.Quote(val)
+	case []string:
+		s := make([]string, len(val))
+		for i := range val {
+			s[i] = strconv.Quote(val[i])
+		}
+		return "[" + strings.Join(s, ",") + "]"
+	case []interface{}:
+		s := make([]string, len(val))
+		for i := range val {
+			s[i] = tomlValue(val[i])
+		}
+		return "[" + strings.Join(s, ",") + "]"
+	}
+	return fmt.Sprint(v)
+}
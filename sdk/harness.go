@@ -0,0 +1,216 @@
+package sdk
+
+import (
+	"agentX/rpc"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	logger "github.com/snail007/mini-logger"
+)
+
+// Harness is an in-memory agent serving plugins on a loopback port,
+// calls go through the real http and websocket transports.
+//   h, err := sdk.NewHarness()
+//   defer h.Close()
+//   err = h.Register(new(MyPlugin), "my", nil)
+//   err = h.CallHTTP("my.Method", args, &reply)
+type Harness struct {
+	Server *rpc.Server
+	// URL is the http url of the agent,use it with ws:// for websocket.
+	URL string
+	// OnRequest answers the requests the agent sends over websocket while
+	// CallWS waits,like rpc.ConfirmMethod,err may be an *rpc.RPCError to set
+	// the error code. They are answered with a method not found error if nil.
+	OnRequest func(method string, params json.RawMessage) (result interface{}, err error)
+
+	mutex  sync.Mutex
+	ws     *websocket.Conn
+	nextID uint64
+}
+
+// NewHarness starts an agent on 127.0.0.1,opts are passed to rpc.New.
+func NewHarness(opts ...rpc.Option) (h *Harness, err error) {
+	opts = append([]rpc.Option{rpc.WithListen("127.0.0.1:0")}, opts...)
+	s, err := rpc.New(opts...)
+	if err != nil {
+		return
+	}
+	s.Start()
+	h = &Harness{
+		Server: s,
+		URL:    fmt.Sprintf("http://%s/harness", s.Addrs()[0]),
+	}
+	return
+}
+
+// Register checks rcvr,registers it as the service name and calls its
+// Init with settings,like agentX does with plugins.
+func (h *Harness) Register(rcvr interface{}, name string, settings map[string]interface{}) (err error) {
+	if err = Check(rcvr); err != nil {
+		return
+	}
+	if err = h.Server.Register(rcvr, name); err != nil {
+		return
+	}
+	if settings == nil {
+		settings = map[string]interface{}{}
+	}
+	return h.Server.Init(name, settings, logger.New(false, nil))
+}
+
+type harnessResponse struct {
+	Method string           `json:"method"`
+	Params *json.RawMessage `json:"params"`
+	Result *json.RawMessage `json:"result"`
+	Error  *rpc.RPCError    `json:"error"`
+	Id     *json.RawMessage `json:"id"`
+}
+
+func request(method string, params interface{}, id uint64) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params, "id": id})
+}
+
+func (r *harnessResponse) decode(reply interface{}) error {
+	if r.Error != nil {
+		return r.Error
+	}
+	if reply == nil || r.Result == nil {
+		return nil
+	}
+	return json.Unmarshal(*r.Result, reply)
+}
+
+// CallHTTP calls method with params over http and decodes the result into
+// reply,a json-rpc error is returned as *rpc.RPCError.
+func (h *Harness) CallHTTP(method string, params interface{}, reply interface{}) (err error) {
+	body, err := request(method, params, 1)
+	if err != nil {
+		return
+	}
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Post(h.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+	r := new(harnessResponse)
+	if err = json.Unmarshal(b, r); err != nil {
+		return fmt.Errorf("bad response %q : %s", b, err)
+	}
+	return r.decode(reply)
+}
+
+// CallWS calls method with params over websocket and decodes the result
+// into reply,progress is called with the data of each progress notification
+// of the call. The requests of the agent meanwhile are answered by
+// OnRequest,other notifications and responses are skipped.
+func (h *Harness) CallWS(method string, params interface{}, reply interface{}, progress func(data json.RawMessage)) (err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.ws == nil {
+		if h.ws, _, err = websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(h.URL, "http"), nil); err != nil {
+			return
+		}
+	}
+	h.nextID++
+	id := h.nextID
+	body, err := request(method, params, id)
+	if err != nil {
+		return
+	}
+	if err = h.ws.WriteMessage(websocket.TextMessage, append(body, '\n')); err != nil {
+		h.ws.Close()
+		h.ws = nil
+		return
+	}
+	for {
+		var b []byte
+		if _, b, err = h.ws.ReadMessage(); err != nil {
+			h.ws.Close()
+			h.ws = nil
+			return
+		}
+		r := new(harnessResponse)
+		if err = json.Unmarshal(b, r); err != nil {
+			return fmt.Errorf("bad response %q : %s", b, err)
+		}
+		switch {
+		case r.Method != "" && r.Id != nil:
+			if err = h.answer(r); err != nil {
+				h.ws.Close()
+				h.ws = nil
+				return
+			}
+		case r.Method == rpc.ProgressMethod:
+			if progress != nil && r.Params != nil {
+				var p struct {
+					Id       uint64          `json:"id"`
+					Progress json.RawMessage `json:"progress"`
+				}
+				if json.Unmarshal(*r.Params, &p) == nil && p.Id == id {
+					progress(p.Progress)
+				}
+			}
+		case r.Method == "" && r.Id != nil:
+			var rid uint64
+			if json.Unmarshal(*r.Id, &rid) == nil && rid == id {
+				return r.decode(reply)
+			}
+		}
+	}
+}
+
+// answer answers the request r of the agent with OnRequest.
+func (h *Harness) answer(r *harnessResponse) error {
+	response := map[string]interface{}{"jsonrpc": "2.0", "id": r.Id}
+	var result interface{}
+	var err error
+	if h.OnRequest == nil {
+		err = rpc.NewError(rpc.E_NO_METHOD, "harness: can't find method "+r.Method, nil)
+	} else {
+		var params json.RawMessage
+		if r.Params != nil {
+			params = *r.Params
+		}
+		result, err = h.OnRequest(r.Method, params)
+	}
+	if err != nil {
+		e, ok := err.(*rpc.RPCError)
+		if !ok {
+			e = rpc.NewError(rpc.E_SERVER, err.Error(), nil)
+		}
+		response["error"] = e
+	} else {
+		response["result"] = result
+	}
+	b, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	return h.ws.WriteMessage(websocket.TextMessage, append(b, '\n'))
+}
+
+// Close closes the websocket connection,the services and the agent.
+func (h *Harness) Close() {
+	h.mutex.Lock()
+	if h.ws != nil {
+		h.ws.Close()
+		h.ws = nil
+	}
+	h.mutex.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	h.Server.Close(ctx)
+}
@@ -0,0 +1,129 @@
+package sdk_test
+
+import (
+	"agentX/rpc"
+	"agentX/sdk"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+type testPlugin struct{}
+
+func (x *testPlugin) Echo(args *string, reply *string) error {
+	*reply = *args
+	return nil
+}
+
+func (x *testPlugin) Steps(ctx *sdk.Context, n *int, reply *int) error {
+	for i := 1; i <= *n; i++ {
+		ctx.Publish("test.step", i)
+		sdk.ReportProgress(ctx, int64(i), int64(*n), "")
+	}
+	*reply = *n
+	return nil
+}
+
+func (x *testPlugin) Ask(ctx *sdk.Context, message *string, reply *bool) (err error) {
+	*reply, err = ctx.Confirm(*message, time.Second*5)
+	return
+}
+
+func (x *testPlugin) Fail(args *string, reply *string) error {
+	return sdk.InvalidParams("bad %s", *args)
+}
+
+func newHarness(t *testing.T, opts ...rpc.Option) *sdk.Harness {
+	h, err := sdk.NewHarness(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = h.Register(new(testPlugin), "test", nil); err != nil {
+		h.Close()
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestHarnessCall(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+	calls := map[string]func(method string, params, reply interface{}) error{
+		"http": h.CallHTTP,
+		"ws": func(method string, params, reply interface{}) error {
+			return h.CallWS(method, params, reply, nil)
+		},
+	}
+	for transport, call := range calls {
+		var reply string
+		if err := call("test.Echo", "hi", &reply); err != nil || reply != "hi" {
+			t.Errorf("%s : Echo = %q,%v", transport, reply, err)
+		}
+		err := call("test.Fail", "x", &reply)
+		if e, ok := err.(*rpc.RPCError); !ok || e.Code != rpc.E_BAD_PARAMS || e.Message != "bad x" {
+			t.Errorf("%s : Fail = %#v", transport, err)
+		}
+	}
+}
+
+func TestHarnessCallWSSkipsOtherMessages(t *testing.T) {
+	h := newHarness(t, rpc.WithSessions(time.Minute, 10))
+	defer h.Close()
+	// the session hello is the first message of the connection
+	h.URL += "?session=new"
+	var sub string
+	if err := h.CallWS(rpc.SubscribeMethod, map[string]interface{}{"topics": []string{"test.*"}}, &sub, nil); err != nil || sub == "" {
+		t.Fatalf("subscribe = %q,%v", sub, err)
+	}
+	for i := 0; i < 3; i++ {
+		var progress []int64
+		var reply int
+		err := h.CallWS("test.Steps", 3, &reply, func(data json.RawMessage) {
+			var p sdk.Progress
+			json.Unmarshal(data, &p)
+			progress = append(progress, p.Done)
+		})
+		if err != nil || reply != 3 || len(progress) != 3 || progress[2] != 3 {
+			t.Fatalf("call %d : Steps = %d,%v,progress %v", i, reply, err, progress)
+		}
+	}
+}
+
+func TestHarnessOnRequest(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+	tests := []struct {
+		name      string
+		onRequest func(method string, params json.RawMessage) (interface{}, error)
+		reply     bool
+		code      rpc.ErrorCode
+	}{
+		{"none", nil, false, rpc.E_NO_METHOD},
+		{"yes", func(method string, params json.RawMessage) (interface{}, error) {
+			var p struct{ Message string }
+			json.Unmarshal(params, &p)
+			return method == rpc.ConfirmMethod && p.Message == "sure?", nil
+		}, true, 0},
+		{"no", func(string, json.RawMessage) (interface{}, error) {
+			return false, nil
+		}, false, 0},
+		{"error", func(string, json.RawMessage) (interface{}, error) {
+			return nil, errors.New("no terminal")
+		}, false, rpc.E_SERVER},
+	}
+	for _, test := range tests {
+		h.OnRequest = test.onRequest
+		var reply bool
+		err := h.CallWS("test.Ask", "sure?", &reply, nil)
+		if test.code != 0 {
+			if e, ok := err.(*rpc.RPCError); !ok || e.Code != test.code {
+				t.Errorf("%s : Ask error = %#v,want code %d", test.name, err, test.code)
+			}
+			continue
+		}
+		if err != nil || reply != test.reply {
+			t.Errorf("%s : Ask = %v,%v,want %v", test.name, reply, err, test.reply)
+		}
+	}
+}
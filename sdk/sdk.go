@@ -0,0 +1,58 @@
+// Package sdk helps writing agentX plugins.
+//
+// a plugin is a type whose exported methods look like:
+//   func (x *T) Method(args *Args, reply *Reply) error
+//   func (x *T) Method(reply *Reply) error
+// optionally taking a *sdk.Context first,and which may implement
+// rpc.Initializer,rpc.HealthChecker and rpc.Closer.
+// Check reports the methods which do not fit,Harness serves a plugin
+// over real http and websocket transports for tests.
+package sdk
+
+import (
+	"agentX/rpc"
+	"fmt"
+)
+
+// Context is passed to methods which take it as first argument.
+type Context = rpc.Context
+
+// Check returns an error listing the exported methods of rcvr which are
+// not rpc methods and why.
+func Check(rcvr interface{}) error {
+	return rpc.Check(rcvr)
+}
+
+// MustCheck is like Check but panics,call it in init() or tests of a plugin.
+func MustCheck(rcvr interface{}) {
+	if err := Check(rcvr); err != nil {
+		panic(err)
+	}
+}
+
+// Errorf returns an error with code,the client receives code and message.
+func Errorf(code rpc.ErrorCode, format string, a ...interface{}) *rpc.RPCError {
+	return rpc.NewError(code, fmt.Sprintf(format, a...), nil)
+}
+
+// InvalidParams returns an invalid params error,use it when args are not usable.
+func InvalidParams(format string, a ...interface{}) *rpc.RPCError {
+	return Errorf(rpc.E_BAD_PARAMS, format, a...)
+}
+
+// ServerError returns a server error carrying data for the client.
+func ServerError(data interface{}, format string, a ...interface{}) *rpc.RPCError {
+	return rpc.NewError(rpc.E_SERVER, fmt.Sprintf(format, a...), data)
+}
+
+// Progress is the data of progress notifications sent by ReportProgress.
+type Progress struct {
+	Done    int64  `json:"done"`
+	Total   int64  `json:"total"`
+	Message string `json:"message,omitempty"`
+}
+
+// ReportProgress sends the progress of the call to the client.
+func ReportProgress(ctx *Context, done, total int64, message string) error {
+	return ctx.Progress(Progress{Done: done, Total: total, Message: message})
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+//makeRaw is only supported on linux,the shell reads plain lines elsewhere
+func makeRaw(fd int) (restore func(), err error) {
+	return nil, errors.New("raw terminal mode is not supported on this platform")
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/spf13/pflag"
+)
+
+//test controller flags
+var (
+	testControllerListen  *string
+	testControllerToken   *string
+	testControllerTLSCert *string
+	testControllerTLSKey  *string
+)
+
+func testControllerFlags(fs *pflag.FlagSet) {
+	testControllerListen = fs.String("listen", ":9443", "address to accept agents on")
+	testControllerToken = fs.String("token", "", "token agents must present,any if empty")
+	testControllerTLSCert = fs.String("tls-cert", "", "certificate file to serve wss")
+	testControllerTLSKey = fs.String("tls-key", "", "key file to serve wss")
+}
+
+//testControllerCommand is `agentx test-controller`,a stand-in of a controller
+//to try the reverse mode: it accepts agents and sends each json-rpc request
+//line read from stdin to every connected agent,the responses are printed
+//prefixed with the agent id.
+func testControllerCommand(args []string) int {
+	if len(args) != 0 {
+		fmt.Fprintln(os.Stderr, "usage: agentx test-controller [--listen :9443] [--token token] [--tls-cert file --tls-key file]")
+		return 2
+	}
+	var mutex sync.Mutex
+	agents := map[string]*websocket.Conn{}
+	upgrader := websocket.Upgrader{}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if *testControllerToken != "" && r.Header.Get("Authorization") != "Bearer "+*testControllerToken {
+			http.Error(w, "bad token", http.StatusUnauthorized)
+			return
+		}
+		id := r.Header.Get("X-AgentX-Id")
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		mutex.Lock()
+		if old := agents[id]; old != nil {
+			old.Close()
+		}
+		agents[id] = c
+		mutex.Unlock()
+		fmt.Fprintf(os.Stderr, "agent %s connected from %s,version %s\n", id, r.RemoteAddr, r.Header.Get("X-AgentX-Version"))
+		c.SetPingHandler(func(data string) error {
+			return c.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second*5))
+		})
+		for {
+			_, message, err := c.ReadMessage()
+			if err != nil {
+				break
+			}
+			for _, line := range strings.Split(strings.TrimSpace(string(message)), "\n") {
+				fmt.Printf("%s %s\n", id, line)
+			}
+		}
+		mutex.Lock()
+		if agents[id] == c {
+			delete(agents, id)
+		}
+		mutex.Unlock()
+		fmt.Fprintf(os.Stderr, "agent %s disconnected\n", id)
+	})
+	go func() {
+		var err error
+		if *testControllerTLSCert != "" {
+			err = http.ListenAndServeTLS(*testControllerListen, *testControllerTLSCert, *testControllerTLSKey, handler)
+		} else {
+			err = http.ListenAndServe(*testControllerListen, handler)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}()
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		mutex.Lock()
+		if len(agents) == 0 {
+			fmt.Fprintln(os.Stderr, "no agent connected")
+		}
+		for id, c := range agents {
+			if err := c.WriteMessage(websocket.TextMessage, []byte(line+"\n")); err != nil {
+				fmt.Fprintf(os.Stderr, "send to %s fail : %s\n", id, err)
+			}
+		}
+		mutex.Unlock()
+	}
+	return 0
+}